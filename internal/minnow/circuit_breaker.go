@@ -0,0 +1,212 @@
+package minnow
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops a processor from running once it has failed too
+// many times in a row.  After a cooldown, or once the processor's
+// definition changes, it lets a single trial run through.  If that
+// succeeds the circuit closes again, otherwise it goes back to open.
+//
+// Breakers belong to a ProcessorId rather than a pool, so they carry over
+// when a processor is reloaded.
+type CircuitBreaker struct {
+	name         string
+	threshold    int // zero disables the breaker
+	cooldown     time.Duration
+	fingerprint  string
+	state        string
+	failures     int
+	openedAt     time.Time
+	trialRunning bool
+	changedChan  chan struct{} // closed and replaced whenever the state changes
+	onHalfOpen   func()
+	mutex        *sync.Mutex
+	logger       *log.Logger
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration, fingerprint string, onHalfOpen func()) *CircuitBreaker {
+	logger := log.New(os.Stdout, "CircuitBreaker: ", 0)
+	return &CircuitBreaker{
+		name,
+		threshold,
+		cooldown,
+		fingerprint,
+		CircuitClosed,
+		0,
+		time.Time{},
+		false,
+		make(chan struct{}),
+		onHalfOpen,
+		new(sync.Mutex),
+		logger,
+	}
+}
+
+// setState must be called with the mutex held.  Returns true if the
+// circuit just went half-open.
+func (breaker *CircuitBreaker) setState(state string) bool {
+	if breaker.state == state {
+		return false
+	}
+
+	breaker.logger.Printf("Circuit for processor %s is now %s", breaker.name, state)
+	breaker.state = state
+	close(breaker.changedChan)
+	breaker.changedChan = make(chan struct{})
+	return state == CircuitHalfOpen
+}
+
+func (breaker *CircuitBreaker) halfOpen() {
+	if breaker.onHalfOpen != nil {
+		breaker.onHalfOpen()
+	}
+}
+
+// Configure updates the breaker's settings when its processor is
+// reloaded.  An open circuit goes half-open if the processor's definition
+// changed, since the change may have fixed it.
+func (breaker *CircuitBreaker) Configure(threshold int, cooldown time.Duration, fingerprint string) {
+	breaker.mutex.Lock()
+	breaker.threshold = threshold
+	breaker.cooldown = cooldown
+	changed := fingerprint != breaker.fingerprint
+	breaker.fingerprint = fingerprint
+	wentHalfOpen := false
+
+	if threshold == 0 {
+		breaker.failures = 0
+		breaker.trialRunning = false
+		breaker.setState(CircuitClosed)
+	} else if changed && breaker.state == CircuitOpen {
+		breaker.logger.Printf("Definition of processor %s changed", breaker.name)
+		wentHalfOpen = breaker.setState(CircuitHalfOpen)
+	}
+
+	breaker.mutex.Unlock()
+
+	if wentHalfOpen {
+		breaker.halfOpen()
+	}
+}
+
+// State returns the state of the circuit, moving it to half-open first if
+// the cooldown is over.
+func (breaker *CircuitBreaker) State() string {
+	breaker.mutex.Lock()
+	wentHalfOpen := false
+
+	if breaker.state == CircuitOpen && time.Since(breaker.openedAt) >= breaker.cooldown {
+		wentHalfOpen = breaker.setState(CircuitHalfOpen)
+	}
+
+	state := breaker.state
+	breaker.mutex.Unlock()
+
+	if wentHalfOpen {
+		breaker.halfOpen()
+	}
+
+	return state
+}
+
+// Wait blocks until a run is allowed through the circuit.  Returns false
+// if cancel is closed first.  A run that was allowed through must be
+// followed by a call to Succeeded, Failed, or Abandoned.
+func (breaker *CircuitBreaker) Wait(cancel <-chan struct{}) bool {
+	for {
+		state := breaker.State()
+
+		breaker.mutex.Lock()
+		changedChan := breaker.changedChan
+		remaining := breaker.cooldown - time.Since(breaker.openedAt)
+
+		switch {
+		case state == CircuitClosed:
+			breaker.mutex.Unlock()
+			return true
+		case state == CircuitHalfOpen && !breaker.trialRunning:
+			breaker.trialRunning = true
+			breaker.mutex.Unlock()
+			return true
+		}
+
+		breaker.mutex.Unlock()
+
+		// an open circuit is re-checked once the cooldown is over
+		if state != CircuitOpen {
+			remaining = time.Duration(1<<63 - 1)
+		}
+
+		timer := time.NewTimer(remaining)
+
+		select {
+		case <-changedChan:
+		case <-timer.C:
+		case <-cancel:
+			timer.Stop()
+			return false
+		}
+
+		timer.Stop()
+	}
+}
+
+// Parking returns true if new runs should be held back rather than
+// queued for the processor.
+func (breaker *CircuitBreaker) Parking() bool {
+	return breaker.State() == CircuitOpen
+}
+
+// Succeeded records a run that succeeded, closing the circuit.
+func (breaker *CircuitBreaker) Succeeded() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.failures = 0
+	breaker.trialRunning = false
+	breaker.setState(CircuitClosed)
+}
+
+// Failed records a run that failed, opening the circuit if there have
+// been too many failures in a row, or if it was the trial run.
+func (breaker *CircuitBreaker) Failed() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.failures++
+	breaker.trialRunning = false
+
+	if breaker.threshold == 0 {
+		return
+	}
+
+	if breaker.state == CircuitHalfOpen || (breaker.state == CircuitClosed && breaker.failures >= breaker.threshold) {
+		breaker.logger.Printf("Processor %s failed %d times in a row, holding its runs for %s", breaker.name, breaker.failures, breaker.cooldown)
+		breaker.openedAt = time.Now()
+		breaker.setState(CircuitOpen)
+	}
+}
+
+// Abandoned gives up a run that was allowed through without recording a
+// result, so another run can be tried.
+func (breaker *CircuitBreaker) Abandoned() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.trialRunning {
+		breaker.trialRunning = false
+		close(breaker.changedChan)
+		breaker.changedChan = make(chan struct{})
+	}
+}
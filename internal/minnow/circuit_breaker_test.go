@@ -0,0 +1,86 @@
+package minnow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	halfOpened := make(chan struct{}, 1)
+	breaker := NewCircuitBreaker("test", 2, time.Duration(100)*time.Millisecond, "a", func() { halfOpened <- struct{}{} })
+
+	breaker.Failed()
+
+	if breaker.State() != CircuitClosed {
+		t.Errorf("Circuit should stay closed below the threshold")
+	}
+
+	breaker.Failed()
+
+	if !breaker.Parking() {
+		t.Fatalf("Circuit should be open after reaching the threshold")
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+
+	if breaker.Wait(cancel) {
+		t.Errorf("Runs should not be allowed while the circuit is open")
+	}
+
+	// the cooldown lets a single trial run through
+	if !breaker.Wait(nil) {
+		t.Fatalf("Trial run should be allowed after the cooldown")
+	}
+
+	select {
+	case <-halfOpened:
+	default:
+		t.Errorf("Parked runs were not released when the circuit went half-open")
+	}
+
+	if breaker.Wait(cancel) {
+		t.Errorf("Only one trial run should be allowed while half-open")
+	}
+
+	breaker.Failed()
+
+	if breaker.State() != CircuitOpen {
+		t.Errorf("Failed trial run should reopen the circuit")
+	}
+
+	breaker.Wait(nil)
+	breaker.Succeeded()
+
+	if breaker.State() != CircuitClosed {
+		t.Errorf("Successful trial run should close the circuit")
+	}
+}
+
+func TestCircuitBreakerDefinitionChange(t *testing.T) {
+	breaker := NewCircuitBreaker("test", 1, time.Hour, "a", nil)
+	breaker.Failed()
+	breaker.Configure(1, time.Hour, "a")
+
+	if breaker.State() != CircuitOpen {
+		t.Errorf("Circuit should stay open if the definition didn't change")
+	}
+
+	breaker.Configure(1, time.Hour, "b")
+
+	if breaker.State() != CircuitHalfOpen {
+		t.Errorf("Circuit should go half-open when the definition changes")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := NewCircuitBreaker("test", 0, time.Hour, "a", nil)
+
+	for i := 0; i < 10; i++ {
+		breaker.Failed()
+	}
+
+	if breaker.State() != CircuitClosed {
+		t.Errorf("Circuit should never open with a threshold of zero")
+	}
+}
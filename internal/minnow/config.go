@@ -9,8 +9,24 @@ import (
 type Config struct {
 	IngestPath               Path
 	IngestMinAge             time.Duration
+	IngestSources            []IngestSource
+	IngestRules              *IngestRules
+	IngestScanInterval       time.Duration
+	IngestCompletion         string
 	WorkPath                 Path
 	ProcessorDefinitionsPath Path
+	ProcessorPollInterval    time.Duration
+	ShutdownGracePeriod      time.Duration
+	QueueCapacity            int
+	QueueOverflow            string
+	ProcessorTimeout         time.Duration
+	FailedPath               Path
+	RejectedPath             Path
+	OrphanTimeout            time.Duration
+	CopyMode                 string
+	LogLevel                 string
+	CircuitBreakerThreshold  int
+	CircuitBreakerCooldown   time.Duration
 }
 
 func ReadConfig(path Path) (Config, error) {
@@ -31,13 +47,13 @@ func ParseConfig(configProperties Properties) (Config, error) {
 	//
 	ingestPathStr, found := configProperties["ingest_dir"]
 
-	if !found {
-		return Config{}, fmt.Errorf("ingest_dir missing from config file")
+	if !found && len(configProperties["ingest_sources"]) == 0 {
+		return Config{}, fmt.Errorf("ingest_dir or ingest_sources missing from config file")
 	}
 
 	config.IngestPath = Path(ingestPathStr)
 
-	if !config.IngestPath.Exists() {
+	if found && !config.IngestPath.Exists() {
 		return Config{}, fmt.Errorf("ingest_dir does not exist at %s", config.IngestPath)
 	}
 
@@ -58,6 +74,81 @@ func ParseConfig(configProperties Properties) (Config, error) {
 
 	config.IngestMinAge = time.Duration(ingestMinAgeInt) * time.Second
 
+	//
+	// ingest_sources
+	//
+	config.IngestSources = make([]IngestSource, 0)
+
+	if len(config.IngestPath) > 0 {
+		// ingest_dir is a source of its own, without any defaults
+		recursive, pathPattern, err := parseIngestLayout(configProperties, "ingest_recursive", "ingest_path_pattern")
+
+		if err != nil {
+			return Config{}, err
+		}
+
+		config.IngestSources = append(config.IngestSources, IngestSource{DefaultIngestSourceName, config.IngestPath, config.IngestMinAge, make(Properties), false, recursive, pathPattern})
+	}
+
+	namedSources, err := parseIngestSources(configProperties, config.IngestMinAge)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	config.IngestSources = append(config.IngestSources, namedSources...)
+
+	if len(config.IngestSources) == 0 {
+		return Config{}, fmt.Errorf("ingest_sources must name at least one source")
+	}
+
+	err = checkIngestSourcesDistinct(config.IngestSources)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	//
+	// ingest_rules
+	//
+	ingestRulesPathStr, found := configProperties["ingest_rules"]
+
+	if found {
+		config.IngestRules, err = ReadIngestRules(Path(ingestRulesPathStr))
+
+		if err != nil {
+			return Config{}, fmt.Errorf("Could not read ingest_rules: %s", err.Error())
+		}
+	}
+
+	//
+	// ingest_scan_interval
+	//
+	config.IngestScanInterval, err = parseSeconds(configProperties, "ingest_scan_interval", time.Duration(1)*time.Minute)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	if config.IngestScanInterval == 0 {
+		return Config{}, fmt.Errorf("ingest_scan_interval must be greater than zero")
+	}
+
+	//
+	// ingest_completion
+	//
+	ingestCompletion, found := configProperties["ingest_completion"]
+
+	if !found {
+		ingestCompletion = IngestCompletionAge
+	}
+
+	if ingestCompletion != IngestCompletionAge && ingestCompletion != IngestCompletionSentinel {
+		return Config{}, fmt.Errorf("ingest_completion must be %s or %s", IngestCompletionAge, IngestCompletionSentinel)
+	}
+
+	config.IngestCompletion = ingestCompletion
+
 	//
 	// work_path
 	//
@@ -88,5 +179,181 @@ func ParseConfig(configProperties Properties) (Config, error) {
 		return Config{}, fmt.Errorf("processor_definitions_path does not exist at %s", config.ProcessorDefinitionsPath)
 	}
 
+	//
+	// processor_poll_interval
+	//
+	config.ProcessorPollInterval, err = parseSeconds(configProperties, "processor_poll_interval", time.Duration(5)*time.Minute)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	if config.ProcessorPollInterval == 0 {
+		return Config{}, fmt.Errorf("processor_poll_interval must be greater than zero")
+	}
+
+	//
+	// shutdown_grace_period
+	//
+	config.ShutdownGracePeriod, err = parseSeconds(configProperties, "shutdown_grace_period", time.Duration(1)*time.Minute)
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	//
+	// queue_capacity
+	//
+	config.QueueCapacity, err = parseNonNegativeInt(configProperties, "queue_capacity", 0) // set default of never pausing ingest
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	//
+	// queue_overflow
+	//
+	queueOverflow, found := configProperties["queue_overflow"]
+
+	if !found {
+		queueOverflow = OverflowBlock
+	}
+
+	if queueOverflow != OverflowBlock && queueOverflow != OverflowReject {
+		return Config{}, fmt.Errorf("queue_overflow must be %s or %s", OverflowBlock, OverflowReject)
+	}
+
+	config.QueueOverflow = queueOverflow
+
+	//
+	// processor_timeout
+	//
+	config.ProcessorTimeout, err = parseSeconds(configProperties, "processor_timeout", 0) // set default of no timeout
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	//
+	// failed_dir
+	//
+	failedPathStr, found := configProperties["failed_dir"]
+
+	if !found {
+		failedPathStr = string(config.WorkPath.JoinPath(Path(FailedDirName))) // set default of a directory in work_dir
+	}
+
+	config.FailedPath = Path(failedPathStr)
+
+	//
+	// rejected_dir
+	//
+	rejectedPathStr, found := configProperties["rejected_dir"]
+
+	if !found {
+		rejectedPathStr = string(config.WorkPath.JoinPath(Path(RejectedDirName))) // set default of a directory in work_dir
+	}
+
+	config.RejectedPath = Path(rejectedPathStr)
+
+	//
+	// orphan_timeout
+	//
+	config.OrphanTimeout, err = parseSeconds(configProperties, "orphan_timeout", 0) // set default of leaving orphans alone
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	//
+	// copy_mode
+	//
+	copyMode, found := configProperties["copy_mode"]
+
+	if !found {
+		copyMode = CopyModeCopy
+	}
+
+	if copyMode != CopyModeCopy && copyMode != CopyModeHardlink && copyMode != CopyModeReflink && copyMode != CopyModeAuto {
+		return Config{}, fmt.Errorf("copy_mode must be %s, %s, %s, or %s", CopyModeCopy, CopyModeHardlink, CopyModeReflink, CopyModeAuto)
+	}
+
+	config.CopyMode = copyMode
+
+	//
+	// log_level
+	//
+	logLevel, found := configProperties["log_level"]
+
+	if !found {
+		logLevel = LogLevelInfo
+	}
+
+	if logLevel != LogLevelInfo && logLevel != LogLevelDebug {
+		return Config{}, fmt.Errorf("log_level must be %s or %s", LogLevelInfo, LogLevelDebug)
+	}
+
+	config.LogLevel = logLevel
+
+	//
+	// circuit_breaker_threshold
+	//
+	config.CircuitBreakerThreshold, err = parseNonNegativeInt(configProperties, "circuit_breaker_threshold", 0) // set default of disabled
+
+	if err != nil {
+		return Config{}, err
+	}
+
+	//
+	// circuit_breaker_cooldown
+	//
+	config.CircuitBreakerCooldown, err = parseSeconds(configProperties, "circuit_breaker_cooldown", time.Duration(5)*time.Minute)
+
+	if err != nil {
+		return Config{}, err
+	}
+
 	return config, nil
 }
+
+func (config Config) ProcessorDefaults() ProcessorDefaults {
+	return ProcessorDefaults{config.ProcessorTimeout, config.CircuitBreakerThreshold, config.CircuitBreakerCooldown}
+}
+
+func (config Config) IngestSettings() IngestSettings {
+	return IngestSettings{config.IngestSources, config.IngestRules, config.IngestCompletion, config.OrphanTimeout}
+}
+
+// parseNonNegativeInt reads an optional integer property, returning
+// defaultValue if it's missing.
+func parseNonNegativeInt(properties Properties, key string, defaultValue int) (int, error) {
+	valueStr, found := properties[key]
+
+	if !found {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(valueStr)
+
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+
+	return value, nil
+}
+
+// parseSeconds reads an optional property holding a number of seconds,
+// returning defaultValue if it's missing.
+func parseSeconds(properties Properties, key string, defaultValue time.Duration) (time.Duration, error) {
+	if _, found := properties[key]; !found {
+		return defaultValue, nil
+	}
+
+	seconds, err := parseNonNegativeInt(properties, key, 0)
+
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a non-negative integer representing seconds", key)
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
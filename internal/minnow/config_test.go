@@ -10,9 +10,18 @@ func TestParseConfig(t *testing.T) {
 	configPropertiesStr := `
 		ingest_dir=/tmp
 		ingest_min_age=600
+		ingest_scan_interval=30
+		ingest_completion=sentinel
 		work_dir=/var
 		work_age_off=86400
-		processor_definitions_dir=/usr`
+		processor_definitions_dir=/usr
+		processor_poll_interval=60
+		shutdown_grace_period=30
+		queue_capacity=5000
+		queue_overflow=reject
+		processor_timeout=3600
+		copy_mode=hardlink
+		log_level=debug`
 	configPropertiesBytes := bytes.NewBufferString(configPropertiesStr).Bytes()
 	configProperties, err := BytesToProperties(configPropertiesBytes)
 
@@ -34,6 +43,14 @@ func TestParseConfig(t *testing.T) {
 		t.Errorf("Incorrect IngestMinAge")
 	}
 
+	if config.IngestScanInterval != time.Duration(30)*time.Second {
+		t.Errorf("Incorrect IngestScanInterval")
+	}
+
+	if config.IngestCompletion != IngestCompletionSentinel {
+		t.Errorf("Incorrect IngestCompletion")
+	}
+
 	if config.WorkPath != "/var" {
 		t.Errorf("Incorrect WorkPath")
 	}
@@ -41,4 +58,64 @@ func TestParseConfig(t *testing.T) {
 	if config.ProcessorDefinitionsPath != "/usr" {
 		t.Errorf("Incorrect ProcessorDefinitionsPath")
 	}
+
+	if config.ProcessorPollInterval != time.Duration(60)*time.Second {
+		t.Errorf("Incorrect ProcessorPollInterval")
+	}
+
+	if config.ShutdownGracePeriod != time.Duration(30)*time.Second {
+		t.Errorf("Incorrect ShutdownGracePeriod")
+	}
+
+	if config.QueueCapacity != 5000 {
+		t.Errorf("Incorrect QueueCapacity")
+	}
+
+	if config.QueueOverflow != OverflowReject {
+		t.Errorf("Incorrect QueueOverflow")
+	}
+
+	if config.ProcessorTimeout != time.Duration(3600)*time.Second {
+		t.Errorf("Incorrect ProcessorTimeout")
+	}
+
+	if config.FailedPath != "/var/failed" {
+		t.Errorf("Incorrect FailedPath")
+	}
+
+	if config.RejectedPath != "/var/rejected" {
+		t.Errorf("Incorrect RejectedPath")
+	}
+
+	if config.OrphanTimeout != 0 {
+		t.Errorf("Incorrect OrphanTimeout")
+	}
+
+	if config.CopyMode != CopyModeHardlink {
+		t.Errorf("Incorrect CopyMode")
+	}
+
+	if config.LogLevel != LogLevelDebug {
+		t.Errorf("Incorrect LogLevel")
+	}
+}
+
+func TestParseConfigInvalidValues(t *testing.T) {
+	tests := map[string]string{
+		"shutdown_grace_period": "shutdown_grace_period must be a non-negative integer representing seconds",
+		"processor_timeout":     "processor_timeout must be a non-negative integer representing seconds",
+		"queue_capacity":        "queue_capacity must be a non-negative integer",
+		"queue_overflow":        "queue_overflow must be block or reject",
+	}
+
+	for key, expected := range tests {
+		for _, value := range []string{"-1", "soon"} {
+			configProperties := Properties{"ingest_dir": "/tmp", "work_dir": "/var", "processor_definitions_dir": "/usr", key: value}
+			_, err := ParseConfig(configProperties)
+
+			if err == nil || err.Error() != expected {
+				t.Errorf("%s=%s should have failed with %q, got %v", key, value, expected, err)
+			}
+		}
+	}
 }
@@ -0,0 +1,62 @@
+package minnow
+
+import (
+	"os"
+)
+
+const (
+	CopyModeCopy     = "copy"
+	CopyModeHardlink = "hardlink"
+	CopyModeReflink  = "reflink"
+	CopyModeAuto     = "auto" // reflink, then hardlink, then copy
+)
+
+// CopyFileWithMode puts the data at source in destination the way mode
+// asks for, falling back to a plain copy when the filesystem can't share
+// the data.  Returns the mode that was actually used.
+func CopyFileWithMode(source, destination Path, mode string) (string, error) {
+	if destination.IsDir() {
+		destination = destination.JoinPath(Path(source.Name()))
+	}
+
+	if mode == CopyModeReflink || mode == CopyModeAuto {
+		if reflinkFile(source, destination) == nil {
+			return CopyModeReflink, nil
+		}
+	}
+
+	if mode == CopyModeHardlink || mode == CopyModeAuto {
+		if hardlinkFile(source, destination) == nil {
+			return CopyModeHardlink, nil
+		}
+	}
+
+	return CopyModeCopy, CopyFile(source, destination)
+}
+
+// hardlinkFile links destination to source.  Every link shares the same
+// data, so the data is made read-only once it's linked, so one processor
+// can't change what another one reads.  If linking fails, source is left
+// as it was.
+func hardlinkFile(source, destination Path) error {
+	perms, err := source.Permissions()
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Link(string(source), string(destination))
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(string(source), perms&^0222)
+
+	if err != nil {
+		destination.Unlink()
+		return err
+	}
+
+	return syncDir(destination.Parent())
+}
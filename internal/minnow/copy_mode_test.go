@@ -0,0 +1,130 @@
+package minnow
+
+import (
+	"os"
+	"testing"
+)
+
+func makeTestCopySource(t *testing.T) (Path, Path) {
+	dir := Path("/tmp/minnow-copy-" + randomString(20))
+	os.MkdirAll(string(dir.JoinPath(Path("input"))), 0700)
+	source := dir.JoinPath(Path("data"))
+	err := source.WriteBytes([]byte("some data"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return source, dir.JoinPath(Path("input"))
+}
+
+func TestCopyFileWithModeCopy(t *testing.T) {
+	source, inputPath := makeTestCopySource(t)
+	usedMode, err := CopyFileWithMode(source, inputPath, CopyModeCopy)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if usedMode != CopyModeCopy {
+		t.Errorf("Used %s instead of copying", usedMode)
+	}
+
+	sourceInfo, _ := os.Stat(string(source))
+	destinationInfo, err := os.Stat(string(inputPath.JoinPath(Path("data"))))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if os.SameFile(sourceInfo, destinationInfo) {
+		t.Errorf("Copy shares an inode with the source")
+	}
+}
+
+func TestCopyFileWithModeHardlink(t *testing.T) {
+	source, inputPath := makeTestCopySource(t)
+	usedMode, err := CopyFileWithMode(source, inputPath, CopyModeHardlink)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if usedMode != CopyModeHardlink {
+		t.Fatalf("Used %s instead of a hardlink", usedMode)
+	}
+
+	sourceInfo, _ := os.Stat(string(source))
+	destinationInfo, err := os.Stat(string(inputPath.JoinPath(Path("data"))))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !os.SameFile(sourceInfo, destinationInfo) {
+		t.Errorf("Hardlink does not share an inode with the source")
+	}
+
+	if destinationInfo.Mode().Perm()&0222 != 0 {
+		t.Errorf("Hardlinked data is writable: %s", destinationInfo.Mode())
+	}
+}
+
+func TestCopyFileWithModeHardlinkFallback(t *testing.T) {
+	source, _ := makeTestCopySource(t)
+	sourceInfo, _ := os.Stat(string(source))
+
+	// links can't cross filesystems, so the data has to be copied
+	inputPath := Path("/dev/shm/minnow-copy-" + randomString(20))
+
+	if inputPath.Mkdir() != nil {
+		t.Skipf("Could not make %s", inputPath)
+	}
+
+	defer inputPath.RmdirRecursive()
+
+	if os.Link(string(source), string(inputPath.JoinPath(Path("probe")))) == nil {
+		t.Skipf("%s is on the same filesystem as %s", inputPath, source)
+	}
+
+	usedMode, err := CopyFileWithMode(source, inputPath, CopyModeHardlink)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if usedMode != CopyModeCopy {
+		t.Fatalf("Used %s instead of falling back to a copy", usedMode)
+	}
+
+	info, err := os.Stat(string(source))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if info.Mode() != sourceInfo.Mode() {
+		t.Errorf("Source mode changed from %s to %s", sourceInfo.Mode(), info.Mode())
+	}
+}
+
+func TestCopyFileWithModeAuto(t *testing.T) {
+	source, inputPath := makeTestCopySource(t)
+
+	// whatever the filesystem supports, the data has to end up there
+	_, err := CopyFileWithMode(source, inputPath, CopyModeAuto)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	content, err := inputPath.JoinPath(Path("data")).ReadBytes()
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if string(content) != "some data" {
+		t.Errorf("Read back %q", content)
+	}
+}
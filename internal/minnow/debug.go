@@ -0,0 +1,27 @@
+package minnow
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+const (
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// debugLogger is for messages that only help when tracking down why
+// something happened, like why a hook didn't match.  It discards everything
+// unless log_level is debug.
+var debugLogger = log.New(io.Discard, "Debug: ", 0)
+
+// SetLogLevel turns debug messages on or off.  It should be called before
+// anything that might log starts.
+func SetLogLevel(level string) {
+	if level == LogLevelDebug {
+		debugLogger.SetOutput(os.Stdout)
+	} else {
+		debugLogger.SetOutput(io.Discard)
+	}
+}
@@ -4,19 +4,23 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 )
 
 type DispatchInfo struct {
 	MetadataPath Path
 	DataPath     Path
 	ProcessedBy  []ProcessorId
+	ProcessorId  ProcessorId // if set, skip hook matching and only send to this processor
 }
 
 type RunRequest struct {
-	InputPath     Path
-	OutputPath    Path
-	ProcessedBy   []ProcessorId
-	IngestDirChan chan IngestDirInfo
+	InputPath      Path
+	OutputPath     Path
+	ProcessedBy    []ProcessorId
+	IngestDirQueue *IngestDirQueue
+	Attempt        int       // zero for the first attempt
+	NotBefore      time.Time // retries wait until this time to run
 }
 
 func (info DispatchInfo) AlreadyProcessedBy(processorId ProcessorId) bool {
@@ -31,101 +35,184 @@ func (info DispatchInfo) AlreadyProcessedBy(processorId ProcessorId) bool {
 
 type Dispatcher struct {
 	workPath          Path
-	dispatchChan      chan DispatchInfo
-	ingestDirChan     chan IngestDirInfo
+	dispatchQueue     *DispatchQueue
+	ingestDirQueue    *IngestDirQueue
 	processorRegistry *ProcessorRegistry
+	copyMode          string
+	warnedCopyMode    bool // whether falling back from copyMode has been logged
 	logger            *log.Logger
 }
 
-func NewDispatcher(workPath Path, dispatchChan chan DispatchInfo, ingestDirChan chan IngestDirInfo, processorRegistry *ProcessorRegistry) (*Dispatcher, error) {
+func NewDispatcher(workPath Path, dispatchQueue *DispatchQueue, ingestDirQueue *IngestDirQueue, processorRegistry *ProcessorRegistry, copyMode string) (*Dispatcher, error) {
 	if !workPath.Exists() {
 		return nil, fmt.Errorf("Work path does not exist: %s", workPath)
 	}
 
 	logger := log.New(os.Stdout, "Dispatcher: ", 0)
-	return &Dispatcher{workPath, dispatchChan, ingestDirChan, processorRegistry, logger}, nil
+	return &Dispatcher{workPath, dispatchQueue, ingestDirQueue, processorRegistry, copyMode, false, logger}, nil
 }
 
 func (dispatcher *Dispatcher) Run() {
-	for dispatchInfo := range dispatcher.dispatchChan {
-		metadata, err := PropertiesFromFile(dispatchInfo.MetadataPath)
+	for {
+		dispatchInfo, entry, err := dispatcher.dispatchQueue.Take(nil)
+
+		if err != nil {
+			dispatcher.logger.Printf("Discarding dispatch entry: %s", err.Error())
+		} else {
+			dispatcher.dispatch(dispatchInfo)
+		}
+
+		// the entry is only removed once the dispatch is finished, so a
+		// restart picks up where this left off
+		err = dispatcher.dispatchQueue.Ack(entry)
 
 		if err != nil {
 			dispatcher.logger.Print(err.Error())
+		}
+	}
+}
+
+func (dispatcher *Dispatcher) dispatch(dispatchInfo DispatchInfo) {
+	metadata, err := PropertiesFromFile(dispatchInfo.MetadataPath)
+
+	if err != nil {
+		dispatcher.logger.Print(err.Error())
+		return
+	}
+
+	// A hook name left over from an earlier processor, or from the run
+	// being replayed, doesn't describe this dispatch, so it's neither
+	// matched on nor passed along.
+	_, hadHookKey := metadata[HookKey]
+	delete(metadata, HookKey)
+
+	var matchingHooks map[ProcessorId]string
+
+	if len(dispatchInfo.ProcessorId) > 0 {
+		if _, err := dispatcher.processorRegistry.ProcessorNameForId(dispatchInfo.ProcessorId); err != nil {
+			// leave it in the work path to be dispatched again at startup
+			dispatcher.logger.Printf("Leaving %s for processor %s, which is not registered", dispatchInfo.DataPath, dispatchInfo.ProcessorId)
+			return
+		}
+
+		// no hook was involved
+		matchingHooks = map[ProcessorId]string{dispatchInfo.ProcessorId: ""}
+	} else {
+		// content hooks only ever see the start of the data, and only
+		// read it if they're asked
+		sampler := NewDataSampler(dispatchInfo.DataPath)
+		matchingHooks = dispatcher.processorRegistry.MatchingHooks(metadata, sampler)
+
+		if err := sampler.Err(); err != nil {
+			dispatcher.logger.Printf("Could not read %s for content hooks: %s", dispatchInfo.DataPath, err.Error())
+		}
+	}
+
+	for processorId, hookName := range matchingHooks {
+		if dispatchInfo.AlreadyProcessedBy(processorId) {
+			dispatcher.logger.Printf("Data at %s already processed by processor %s. Will not process again.", dispatchInfo.DataPath, processorId)
 			continue
 		}
 
-		matchingProcessorIds := dispatcher.processorRegistry.MatchingProcessorIds(metadata)
+		processorName, err := dispatcher.processorRegistry.ProcessorNameForId(processorId)
 
-		for _, processorId := range matchingProcessorIds {
-			if dispatchInfo.AlreadyProcessedBy(processorId) {
-				dispatcher.logger.Printf("Data at %s already processed by processor %s. Will not process again.", dispatchInfo.DataPath, processorId)
-				continue
-			}
+		if err != nil {
+			dispatcher.logger.Print(err.Error())
+			processorName = "unknownprocessor"
+		}
 
-			processorName, err := dispatcher.processorRegistry.ProcessorNameForId(processorId)
+		inputPath, err := makeRandomPath(dispatcher.workPath, processorName+"-input")
 
-			if err != nil {
-				dispatcher.logger.Print(err.Error())
-				processorName = "unknownprocessor"
-			}
+		if err != nil {
+			dispatcher.logger.Printf("Error creating input path for dispatch: %s", err.Error())
+			continue
+		}
 
-			inputPath, err := makeRandomPath(dispatcher.workPath, processorName+"-input")
+		outputPath, err := makeRandomPath(dispatcher.workPath, processorName+"-output")
 
-			if err != nil {
-				dispatcher.logger.Printf("Error creating input path for dispatch: %s", err.Error())
-				continue
-			}
+		if err != nil {
+			dispatcher.logger.Printf("Error creating output path for dispatch: %s", err.Error())
+			continue
+		}
 
-			outputPath, err := makeRandomPath(dispatcher.workPath, processorName+"-output")
+		// Need to resolve the input and output paths so
+		// clean, _absolute_ paths get passed to the processor.
+		inputPath, err = inputPath.Resolve()
 
-			if err != nil {
-				dispatcher.logger.Printf("Error creating output path for dispatch: %s", err.Error())
-				continue
-			}
+		if err != nil {
+			dispatcher.logger.Print(err.Error())
+			continue
+		}
 
-			// Need to resolve the input and output paths so
-			// clean, _absolute_ paths get passed to the processor.
-			inputPath, err = inputPath.Resolve()
+		outputPath, err = outputPath.Resolve()
 
-			if err != nil {
-				dispatcher.logger.Print(err.Error())
-				continue
-			}
+		if err != nil {
+			dispatcher.logger.Print(err.Error())
+			continue
+		}
+
+		// copy metadata into the new input path, telling the processor
+		// which of its hooks matched
+		if len(hookName) > 0 || hadHookKey {
+			runMetadata := make(Properties, len(metadata)+1)
 
-			outputPath, err = outputPath.Resolve()
+			for key, value := range metadata {
+				runMetadata[key] = value
+			}
 
-			if err != nil {
-				dispatcher.logger.Print(err.Error())
-				continue
+			if len(hookName) > 0 {
+				dispatcher.logger.Printf("Sending %s to processor %s, matched by hook %s", dispatchInfo.DataPath, processorName, hookName)
+				runMetadata[HookKey] = hookName
 			}
 
-			// copy metadata into the new input path
+			err = inputPath.JoinPath(Path(dispatchInfo.MetadataPath.Name())).WriteBytes(runMetadata.ToBytes())
+		} else {
 			err = CopyFile(dispatchInfo.MetadataPath, inputPath)
+		}
 
-			if err != nil {
-				dispatcher.logger.Printf("Error copying metadata to work path: %s", err.Error())
-				continue
-			}
+		if err != nil {
+			dispatcher.logger.Printf("Error copying metadata to work path: %s", err.Error())
+			continue
+		}
 
-			// copy data into the new input path
-			err = CopyFile(dispatchInfo.DataPath, inputPath)
-			if err != nil {
-				dispatcher.logger.Printf("Error copying data to work path: %s", err.Error())
-				continue
-			}
+		// copy data into the new input path, sharing it with the other
+		// copies where the filesystem allows
+		usedMode, err := CopyFileWithMode(dispatchInfo.DataPath, inputPath, dispatcher.copyMode)
 
-			// copy the ProcessedBy slice so multiple processors
-			// don't update the same slice
-			processedByCopy := make([]ProcessorId, len(dispatchInfo.ProcessedBy))
-			copy(processedByCopy, dispatchInfo.ProcessedBy)
+		if err != nil {
+			dispatcher.logger.Printf("Error copying data to work path: %s", err.Error())
+			continue
+		}
 
-			runRequest := RunRequest{inputPath, outputPath, processedByCopy, dispatcher.ingestDirChan}
-			dispatcher.processorRegistry.SendToProcessorId(processorId, runRequest)
+		if dispatcher.copyMode != CopyModeCopy && usedMode == CopyModeCopy && !dispatcher.warnedCopyMode {
+			dispatcher.logger.Printf("Could not use copy_mode %s for %s, copying instead", dispatcher.copyMode, dispatchInfo.DataPath)
+			dispatcher.warnedCopyMode = true
 		}
 
-		// copies have been sent to all matching processors,
-		// so the original is no longer needed
-		dispatchInfo.MetadataPath.Parent().RmdirRecursive()
+		// copy the ProcessedBy slice so multiple processors
+		// don't update the same slice
+		processedByCopy := make([]ProcessorId, len(dispatchInfo.ProcessedBy))
+		copy(processedByCopy, dispatchInfo.ProcessedBy)
+
+		runState := WorkState{WorkKindRun, RunStatusQueued, processorId, processedByCopy, inputPath, outputPath, 0, make([]Path, 0), 0, ""}
+		err = WriteWorkState(inputPath, runState)
+
+		if err != nil {
+			dispatcher.logger.Printf("Error writing run state: %s", err.Error())
+			continue
+		}
+
+		runRequest := RunRequest{inputPath, outputPath, processedByCopy, dispatcher.ingestDirQueue, 0, time.Time{}}
+		err = dispatcher.processorRegistry.SendToProcessorId(processorId, runRequest)
+
+		if err != nil {
+			dispatcher.logger.Print(err.Error())
+		}
 	}
+
+	// copies have been sent to all matching processors,
+	// so the original is no longer needed
+	dispatchPath := dispatchInfo.MetadataPath.Parent()
+	dispatchPath.RmdirRecursive()
+	RemoveWorkState(dispatchPath)
 }
@@ -0,0 +1,70 @@
+package minnow
+
+import (
+	"testing"
+)
+
+func TestDispatchSetsHookKeyPerRun(t *testing.T) {
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	workPath.Mkdir()
+	defer workPath.RmdirRecursive()
+
+	// without workers, runs stay queued so their input can be checked
+	definitionsPath := workPath.JoinPath("processors")
+	definitionsPath.Mkdir()
+	makeTestProcessorDefinition(t, "pool_size = 0", "true").Rename(definitionsPath.JoinPath("test"))
+	registry := makeTestRegistry(t, definitionsPath, workPath)
+	defer registry.Shutdown(0)
+	dispatchQueue, err := NewDispatchQueue(workPath.JoinPath("spool", "dispatch"), 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	dispatcher, err := NewDispatcher(workPath, dispatchQueue, registry.ingestDirQueue, registry, CopyModeCopy)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var processorId ProcessorId
+	var runRequestQueue *RunRequestQueue
+
+	for id, queue := range registry.runRequestQueues {
+		processorId, runRequestQueue = id, queue
+	}
+
+	// a hook name from upstream is replaced by the one that matched, and
+	// a targeted dispatch, like a replay, doesn't get one at all
+	tests := map[ProcessorId]string{"": "hook.properties", processorId: ""}
+
+	for targetId, expected := range tests {
+		dispatchPath, err := makeRandomPath(workPath, "dispatch")
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		dispatchPath.JoinPath("data.txt").WriteBytes([]byte("data"))
+		metadataPath := dispatchPath.JoinPath("data.txt" + PropertiesExtension)
+		metadataPath.WriteBytes([]byte("type = test\n" + HookKey + " = upstream.properties"))
+		dispatcher.dispatch(DispatchInfo{metadataPath, dispatchPath.JoinPath("data.txt"), make([]ProcessorId, 0), targetId})
+
+		runRequest, entry, err := runRequestQueue.TryTake()
+
+		if err != nil {
+			t.Fatalf("Expected a run to be queued: %s", err.Error())
+		}
+
+		runRequestQueue.Ack(entry)
+		metadata, err := PropertiesFromFile(runRequest.InputPath.JoinPath("data.txt" + PropertiesExtension))
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if hookName, found := metadata[HookKey]; hookName != expected || found != (len(expected) > 0) {
+			t.Errorf("Dispatch to %q gave %s = %q, expected %q", targetId, HookKey, hookName, expected)
+		}
+	}
+}
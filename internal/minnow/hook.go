@@ -1,16 +1,161 @@
 package minnow
 
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	BasicPropertiesMatchHookType = "basicpropertiesmatchhook"
+	GlobMatchHookType            = "globmatchhook"
+	RegexMatchHookType           = "regexmatchhook"
+	ExpressionHookType           = "expressionhook"
+	ContentMatchHookType         = "contentmatchhook"
+
+	// directory of hook files used when a processor has no hook_file
+	HooksDirName = "hooks"
+
+	// metadata key giving the processor the name of the hook that matched
+	HookKey = "minnow_hook"
+)
+
+// Hook matches metadata.  Hooks that look at the data file itself are
+// DataHooks instead.
 type Hook interface {
 	MatchesBytes([]byte) bool
 	Matches(Properties) bool
 }
 
+// NamedHook is a hook along with the name of the file it came from,
+// relative to the processor's definition directory.  Exactly one of Hook
+// and DataHook is set.
+type NamedHook struct {
+	Name     string
+	Hook     Hook
+	DataHook DataHook
+}
+
+// Hooks are all of a processor's hooks.  The processor gets anything that
+// any of them matches.
+type Hooks []NamedHook
+
+// Match returns the name of the first hook that matches properties, or
+// the sample of the data for hooks that look at the data itself.  The
+// sample is only read if one of those hooks gets that far.  Returns false
+// if none of them match.
+func (hooks Hooks) Match(properties Properties, sampler *DataSampler) (string, bool) {
+	for _, hook := range hooks {
+		if hook.DataHook != nil {
+			if hook.DataHook.MatchesData(sampler.Sample()) {
+				return hook.Name, true
+			}
+		} else if hook.Hook.Matches(properties) {
+			return hook.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// LoadHook loads the hook file at path.  If the first line of the file is
+// like hook_type = globmatchhook, that's its type, and the line isn't part
+// of the hook.  Otherwise, the file is loaded as hookType.
+func LoadHook(name, hookType string, path Path) (NamedHook, error) {
+	contents, err := path.ReadBytes()
+
+	if err != nil {
+		return NamedHook{}, err
+	}
+
+	if fileHookType, rest, found := splitHookType(contents); found {
+		hookType, contents = fileHookType, rest
+	}
+
+	if hookType == ContentMatchHookType {
+		dataHook, err := newContentMatchHook(path, contents)
+
+		if err != nil {
+			return NamedHook{}, err
+		}
+
+		return NamedHook{name, nil, dataHook}, nil
+	}
+
+	hook, err := newHook(hookType, path, contents)
+
+	if err != nil {
+		return NamedHook{}, err
+	}
+
+	return NamedHook{name, hook, nil}, nil
+}
+
+// splitHookType returns the hook_type from the first line of a hook file,
+// and the rest of the file after it.
+func splitHookType(contents []byte) (string, []byte, bool) {
+	lines := bytes.SplitN(bytes.TrimLeft(contents, " \t\r\n"), []byte("\n"), 2)
+	parts := strings.Split(string(lines[0]), "=")
+
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) != "hook_type" {
+		return "", contents, false
+	}
+
+	rest := []byte{}
+
+	if len(lines) > 1 {
+		rest = lines[1]
+	}
+
+	return strings.TrimSpace(parts[1]), rest, true
+}
+
+// NewHookFromFile loads the hook file at path as the given hook_type, which
+// has to be one that matches metadata.
+func NewHookFromFile(hookType string, path Path) (Hook, error) {
+	contents, err := path.ReadBytes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newHook(hookType, path, contents)
+}
+
+func newHook(hookType string, path Path, contents []byte) (Hook, error) {
+	switch hookType {
+	case BasicPropertiesMatchHookType:
+		return newBasicPropertiesMatchHook(contents)
+	case GlobMatchHookType:
+		return newGlobMatchHook(path, contents)
+	case RegexMatchHookType:
+		return newRegexMatchHook(path, contents)
+	case ExpressionHookType:
+		return newExpressionHook(path, contents)
+	case ContentMatchHookType:
+		return nil, fmt.Errorf("%s matches data, not metadata, in %s", hookType, path)
+	}
+
+	return nil, fmt.Errorf("Unknown hook_type %s in %s", hookType, path)
+}
+
 type BasicPropertiesMatchHook struct {
 	match Properties
 }
 
 func NewBasicPropertiesMatchHookFromFile(path Path) (BasicPropertiesMatchHook, error) {
-	hookProperties, err := PropertiesFromFile(path)
+	contents, err := path.ReadBytes()
+
+	if err != nil {
+		return BasicPropertiesMatchHook{}, err
+	}
+
+	return newBasicPropertiesMatchHook(contents)
+}
+
+func newBasicPropertiesMatchHook(contents []byte) (BasicPropertiesMatchHook, error) {
+	hookProperties, err := BytesToProperties(contents)
 
 	if err != nil {
 		return BasicPropertiesMatchHook{}, err
@@ -43,3 +188,159 @@ func (hook BasicPropertiesMatchHook) MatchesBytes(matchAgainst []byte) bool {
 
 	return hook.Matches(properties)
 }
+
+// RegexMatchHook matches metadata that has every key in the hook, with a
+// value the key's regular expression matches somewhere, so patterns that
+// have to match the whole value need ^ and $.
+type RegexMatchHook struct {
+	match map[string]*regexp.Regexp
+}
+
+func NewRegexMatchHookFromFile(path Path) (RegexMatchHook, error) {
+	contents, err := path.ReadBytes()
+
+	if err != nil {
+		return RegexMatchHook{}, err
+	}
+
+	return newRegexMatchHook(path, contents)
+}
+
+func newRegexMatchHook(path Path, contents []byte) (RegexMatchHook, error) {
+	hookProperties, err := BytesToProperties(contents)
+
+	if err != nil {
+		return RegexMatchHook{}, err
+	}
+
+	match := make(map[string]*regexp.Regexp)
+
+	for key, pattern := range hookProperties {
+		regex, err := regexp.Compile(pattern)
+
+		if err != nil {
+			return RegexMatchHook{}, fmt.Errorf("Invalid regular expression for %s in %s: %s", key, path, err.Error())
+		}
+
+		match[key] = regex
+	}
+
+	return RegexMatchHook{match}, nil
+}
+
+func (hook RegexMatchHook) Matches(matchAgainst Properties) bool {
+	for key, regex := range hook.match {
+		value, found := matchAgainst[key]
+
+		if !found || !regex.MatchString(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (hook RegexMatchHook) MatchesBytes(matchAgainst []byte) bool {
+	properties, err := BytesToProperties(matchAgainst)
+
+	if err != nil {
+		return false
+	}
+
+	return hook.Matches(properties)
+}
+
+// GlobMatchHook is like RegexMatchHook, but each value in the hook is a
+// glob that has to match the whole value, like *.csv.  Unlike a path glob,
+// * matches / too, since metadata values aren't paths.  The globs are
+// translated to regular expressions when the hook is loaded.
+type GlobMatchHook struct {
+	RegexMatchHook
+}
+
+func NewGlobMatchHookFromFile(path Path) (GlobMatchHook, error) {
+	contents, err := path.ReadBytes()
+
+	if err != nil {
+		return GlobMatchHook{}, err
+	}
+
+	return newGlobMatchHook(path, contents)
+}
+
+func newGlobMatchHook(path Path, contents []byte) (GlobMatchHook, error) {
+	hookProperties, err := BytesToProperties(contents)
+
+	if err != nil {
+		return GlobMatchHook{}, err
+	}
+
+	match := make(map[string]*regexp.Regexp)
+
+	for key, glob := range hookProperties {
+		regexStr, err := valueGlobToRegex(glob)
+
+		if err == nil {
+			match[key], err = regexp.Compile(regexStr)
+		}
+
+		if err != nil {
+			return GlobMatchHook{}, fmt.Errorf("Invalid glob for %s in %s: %s", key, path, glob)
+		}
+	}
+
+	return GlobMatchHook{RegexMatchHook{match}}, nil
+}
+
+// valueGlobToRegex translates a glob for a metadata value to an anchored
+// regular expression.  * matches any run of characters, ? matches any one,
+// [...] matches a character class, negated with a leading !, and \ makes
+// the next character literal.
+func valueGlobToRegex(glob string) (string, error) {
+	var buffer strings.Builder
+	buffer.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			buffer.WriteString(".*")
+		case '?':
+			buffer.WriteString(".")
+		case '\\':
+			if i+1 == len(glob) {
+				return "", fmt.Errorf("trailing \\ in %s", glob)
+			}
+
+			i++
+			buffer.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case '[':
+			end := i + 1
+
+			for end < len(glob) && glob[end] != ']' {
+				if glob[end] == '\\' {
+					end++
+				}
+
+				end++
+			}
+
+			if end >= len(glob) {
+				return "", fmt.Errorf("unclosed [ in %s", glob)
+			}
+
+			class := glob[i+1 : end]
+
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			buffer.WriteString("[" + class + "]")
+			i = end
+		default:
+			buffer.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+
+	buffer.WriteString("$")
+	return buffer.String(), nil
+}
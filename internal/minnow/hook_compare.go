@@ -0,0 +1,207 @@
+package minnow
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateFormat is accepted for timestamps, meaning midnight UTC.
+const dateFormat = "2006-01-02"
+
+// typedValue holds a metadata value or literal parsed as one of the
+// exprTypes.  Only the field for that type is set.
+type typedValue struct {
+	number    float64
+	timestamp time.Time
+	duration  time.Duration
+	version   semanticVersion
+}
+
+// exprType is a type that hook expressions can order values as.  A literal
+// gets the first type it parses as, and metadata values are parsed as the
+// literal's type when they're compared with it.
+type exprType struct {
+	name    string
+	parse   func(string) (typedValue, error)
+	compare func(a, b typedValue) int
+}
+
+var (
+	exprNumberType = &exprType{"number", parseNumberValue, func(a, b typedValue) int {
+		return compareOrdered(a.number < b.number, a.number > b.number)
+	}}
+	exprTimestampType = &exprType{"timestamp", parseTimestampValue, func(a, b typedValue) int {
+		return compareOrdered(a.timestamp.Before(b.timestamp), a.timestamp.After(b.timestamp))
+	}}
+	exprDurationType = &exprType{"duration", parseDurationValue, func(a, b typedValue) int {
+		return compareOrdered(a.duration < b.duration, a.duration > b.duration)
+	}}
+	exprVersionType = &exprType{"version", parseVersionValue, func(a, b typedValue) int {
+		return a.version.compare(b.version)
+	}}
+
+	// in the order literals are tried
+	exprTypes = []*exprType{exprNumberType, exprTimestampType, exprDurationType, exprVersionType}
+)
+
+// literalType returns the first type text parses as.
+func literalType(text string) (*exprType, typedValue, bool) {
+	for _, valueType := range exprTypes {
+		if value, err := valueType.parse(text); err == nil {
+			return valueType, value, true
+		}
+	}
+
+	return nil, typedValue{}, false
+}
+
+func compareOrdered(less, greater bool) int {
+	if less {
+		return -1
+	}
+
+	if greater {
+		return 1
+	}
+
+	return 0
+}
+
+func parseNumberValue(text string) (typedValue, error) {
+	number, err := strconv.ParseFloat(text, 64)
+
+	if err != nil || math.IsNaN(number) {
+		return typedValue{}, fmt.Errorf("%s is not a number", text)
+	}
+
+	return typedValue{number: number}, nil
+}
+
+// parseTimestampValue accepts RFC 3339 timestamps, like
+// 2026-01-01T12:00:00Z, or plain dates.
+func parseTimestampValue(text string) (typedValue, error) {
+	timestamp, err := time.Parse(time.RFC3339Nano, text)
+
+	if err != nil {
+		timestamp, err = time.Parse(dateFormat, text)
+	}
+
+	if err != nil {
+		return typedValue{}, fmt.Errorf("%s is not a timestamp", text)
+	}
+
+	return typedValue{timestamp: timestamp}, nil
+}
+
+// parseDurationValue accepts durations like 90s or 1h30m.
+func parseDurationValue(text string) (typedValue, error) {
+	duration, err := time.ParseDuration(text)
+
+	if err != nil {
+		return typedValue{}, fmt.Errorf("%s is not a duration", text)
+	}
+
+	return typedValue{duration: duration}, nil
+}
+
+func parseVersionValue(text string) (typedValue, error) {
+	version, err := parseSemanticVersion(text)
+
+	if err != nil {
+		return typedValue{}, err
+	}
+
+	return typedValue{version: version}, nil
+}
+
+// semanticVersion is a version like 1.4.2 or 2.0.0-rc.1, ordered the way
+// https://semver.org says to.
+type semanticVersion struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+// parseSemanticVersion allows a leading v, and missing minor or patch
+// numbers, which count as zero.  Build metadata after a + is ignored, as it
+// doesn't affect ordering.
+func parseSemanticVersion(text string) (semanticVersion, error) {
+	invalid := fmt.Errorf("%s is not a version", text)
+	versionStr := strings.TrimPrefix(text, "v")
+
+	if i := strings.IndexByte(versionStr, '+'); i >= 0 {
+		versionStr = versionStr[:i]
+	}
+
+	version := semanticVersion{}
+
+	if i := strings.IndexByte(versionStr, '-'); i >= 0 {
+		version.prerelease = strings.Split(versionStr[i+1:], ".")
+		versionStr = versionStr[:i]
+
+		for _, identifier := range version.prerelease {
+			if len(identifier) == 0 {
+				return semanticVersion{}, invalid
+			}
+		}
+	}
+
+	parts := strings.Split(versionStr, ".")
+
+	if len(parts) > len(version.numbers) {
+		return semanticVersion{}, invalid
+	}
+
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+
+		if err != nil || number < 0 || strings.HasPrefix(part, "+") {
+			return semanticVersion{}, invalid
+		}
+
+		version.numbers[i] = number
+	}
+
+	return version, nil
+}
+
+func (version semanticVersion) compare(other semanticVersion) int {
+	for i := range version.numbers {
+		if c := compareOrdered(version.numbers[i] < other.numbers[i], version.numbers[i] > other.numbers[i]); c != 0 {
+			return c
+		}
+	}
+
+	// a pre-release comes before the release itself
+	if len(version.prerelease) == 0 || len(other.prerelease) == 0 {
+		return compareOrdered(len(version.prerelease) > len(other.prerelease), len(version.prerelease) < len(other.prerelease))
+	}
+
+	for i := 0; i < len(version.prerelease) && i < len(other.prerelease); i++ {
+		if c := comparePrerelease(version.prerelease[i], other.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+
+	return compareOrdered(len(version.prerelease) < len(other.prerelease), len(version.prerelease) > len(other.prerelease))
+}
+
+// comparePrerelease orders numeric identifiers numerically, and before any
+// others, which are ordered as strings.
+func comparePrerelease(a, b string) int {
+	aNumber, aErr := strconv.Atoi(a)
+	bNumber, bErr := strconv.Atoi(b)
+
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareOrdered(aNumber < bNumber, aNumber > bNumber)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
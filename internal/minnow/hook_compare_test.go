@@ -0,0 +1,113 @@
+package minnow
+
+import (
+	"testing"
+)
+
+func TestTypedComparisons(t *testing.T) {
+	metadata := Properties{
+		"resolution":  "300",
+		"captured_at": "2026-03-14T09:26:53Z",
+		"exposure":    "1m30s",
+		"app_version": "v2.10.0",
+		"label":       "unknown",
+	}
+	tests := map[string]bool{
+		`resolution >= 300`:                          true,
+		`resolution > 300`:                           false,
+		`resolution < 1e3`:                           true,
+		`captured_at after 2026-01-01`:               true,
+		`captured_at before 2026-01-01`:              false,
+		`captured_at < 2026-03-14T10:00:00Z`:         true,
+		`captured_at >= "2026-03-14T10:26:53+01:00"`: true,
+		`exposure > 90s`:                             false,
+		`exposure >= 1m30s`:                          true,
+		`exposure < 2m`:                              true,
+		`app_version > 2.9.0`:                        true,
+		`app_version < v2.10.1`:                      true,
+		`app_version >= 2.10.0-rc.1`:                 true,
+		`label > 100`:                                false, // not a number
+		`label after 2026-01-01`:                     false, // not a timestamp
+		`not label < 1.0.0`:                          true,
+	}
+
+	for expression, expected := range tests {
+		root, err := parseExpression(expression)
+
+		if err != nil {
+			t.Errorf("Could not parse %q: %s", expression, err.Error())
+			continue
+		}
+
+		if root.eval(metadata) != expected {
+			t.Errorf("%q should have returned %t", expression, expected)
+		}
+	}
+}
+
+func TestVersionLiterals(t *testing.T) {
+	tests := []struct {
+		expression string
+		version    string
+		expected   bool
+	}{
+		{`app_version >= version(2.10)`, "2.9", false},
+		{`app_version >= version(2.10)`, "v2.10.0", true},
+		{`app_version >= version(2.10)`, "2.10", true},
+		{`app_version < version(2)`, "1.9.9", true},
+		{`app_version >= v2.10`, "2.9", false},
+		{`app_version >= v2.10`, "v2.10.0", true},
+		{`app_version >= 2.10`, "2.9", true}, // a plain 2.10 is a number
+		{`app_version >= 2.10`, "v2.10.0", false},
+	}
+
+	for _, test := range tests {
+		root, err := parseExpression(test.expression)
+
+		if err != nil {
+			t.Errorf("Could not parse %q: %s", test.expression, err.Error())
+			continue
+		}
+
+		if root.eval(Properties{"app_version": test.version}) != test.expected {
+			t.Errorf("%q with app_version = %s should have returned %t", test.expression, test.version, test.expected)
+		}
+	}
+}
+
+func TestTypedComparisonErrors(t *testing.T) {
+	for _, expression := range []string{`size > big`, `captured_at after 100`, `version < 1.2.3.4`, `v < version(1.x)`, `v < version(1.0`, `v = version(1.0)`, `d > duration(2.10)`, `n < number(1.2.3)`} {
+		if _, err := parseExpression(expression); err == nil {
+			t.Errorf("%q should not have parsed", expression)
+		}
+	}
+}
+
+func TestSemanticVersionOrder(t *testing.T) {
+	// from https://semver.org, in increasing order
+	versions := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1+build.5", "1.1", "v2"}
+
+	for i := 0; i+1 < len(versions); i++ {
+		lower, err := parseSemanticVersion(versions[i])
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		higher, err := parseSemanticVersion(versions[i+1])
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if lower.compare(higher) >= 0 || higher.compare(lower) <= 0 {
+			t.Errorf("%s should come before %s", versions[i], versions[i+1])
+		}
+	}
+
+	for _, invalid := range []string{"", "1.x", "1.0.0-", "1.0.0-a..b", "-1.0"} {
+		if _, err := parseSemanticVersion(invalid); err == nil {
+			t.Errorf("%q should not have parsed", invalid)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package minnow
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DataSampleSize is how much of a data file content hooks get to see.
+const DataSampleSize = 64 * 1024
+
+// DataSample is what content hooks know about a data file: its name, its
+// size, and up to DataSampleSize bytes from the start of it.
+type DataSample struct {
+	Name  string
+	Size  int64
+	Head  []byte
+	Valid bool // false if the data file couldn't be read
+}
+
+// ReadDataSample reads the start of the data file at dataPath.
+func ReadDataSample(dataPath Path) (DataSample, error) {
+	file, err := os.Open(string(dataPath))
+
+	if err != nil {
+		return DataSample{}, err
+	}
+
+	defer file.Close()
+	info, err := file.Stat()
+
+	if err != nil {
+		return DataSample{}, err
+	}
+
+	head := make([]byte, DataSampleSize)
+	n, err := io.ReadFull(file, head)
+
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return DataSample{}, err
+	}
+
+	return DataSample{dataPath.Name(), info.Size(), head[:n], true}, nil
+}
+
+// DataSampler reads the sample of a data file the first time a content
+// hook asks for it, so data that no content hook looks at is never read.
+type DataSampler struct {
+	dataPath Path
+	sample   *DataSample
+	err      error
+}
+
+func NewDataSampler(dataPath Path) *DataSampler {
+	return &DataSampler{dataPath, nil, nil}
+}
+
+// Sample returns the sample of the data file, which isn't Valid if the file
+// couldn't be read, or if there is no sampler.
+func (sampler *DataSampler) Sample() DataSample {
+	if sampler == nil {
+		return DataSample{}
+	}
+
+	if sampler.sample == nil {
+		sample, err := ReadDataSample(sampler.dataPath)
+		sampler.sample, sampler.err = &sample, err
+	}
+
+	return *sampler.sample
+}
+
+// Err returns the error from reading the sample, if it was read.
+func (sampler *DataSampler) Err() error {
+	if sampler == nil {
+		return nil
+	}
+
+	return sampler.err
+}
+
+// DataHook is a hook that looks at the data file itself, rather than its
+// metadata.  It isn't a Hook, so it can't be mistaken for one that only
+// needs the metadata.
+type DataHook interface {
+	MatchesData(DataSample) bool
+}
+
+// ContentMatchHook matches data files by what they are, whatever their
+// metadata says.  Every condition in the hook file has to hold:
+//
+//	mime_type = application/pdf, image/*
+//	magic = 25504446
+//	extension = pdf
+//	min_size = 1M
+//	max_size = 2G
+//	content_regex = ^%PDF-1\.[4-7]
+//	content_bytes = 1024
+//
+// Lists match if any of their entries do.  The MIME type is sniffed from
+// the first 512 bytes, and the regex sees at most content_bytes, which
+// can't be more than DataSampleSize.
+type ContentMatchHook struct {
+	mimeTypes    []string // globs
+	magics       [][]byte
+	extensions   []string // lower case, with the leading dot
+	minSize      int64
+	maxSize      int64 // negative for no limit
+	contentRegex *regexp.Regexp
+	contentBytes int
+}
+
+func NewContentMatchHookFromFile(hookPath Path) (ContentMatchHook, error) {
+	contents, err := hookPath.ReadBytes()
+
+	if err != nil {
+		return ContentMatchHook{}, err
+	}
+
+	return newContentMatchHook(hookPath, contents)
+}
+
+func newContentMatchHook(hookPath Path, contents []byte) (ContentMatchHook, error) {
+	hookProperties, err := BytesToProperties(contents)
+
+	if err != nil {
+		return ContentMatchHook{}, err
+	}
+
+	hook, err := parseContentMatchHook(hookProperties)
+
+	if err != nil {
+		return ContentMatchHook{}, fmt.Errorf("Invalid content hook %s: %s", hookPath, err.Error())
+	}
+
+	return hook, nil
+}
+
+func parseContentMatchHook(properties Properties) (ContentMatchHook, error) {
+	hook := ContentMatchHook{maxSize: -1, contentBytes: DataSampleSize}
+
+	for key, value := range properties {
+		var err error
+
+		switch key {
+		case "mime_type":
+			for _, mimeType := range splitList(value) {
+				if _, err = path.Match(mimeType, ""); err != nil {
+					return ContentMatchHook{}, fmt.Errorf("invalid mime_type %s", mimeType)
+				}
+
+				hook.mimeTypes = append(hook.mimeTypes, strings.ToLower(mimeType))
+			}
+		case "magic":
+			for _, magicStr := range splitList(value) {
+				magic, err := hex.DecodeString(magicStr)
+
+				if err != nil || len(magic) == 0 {
+					return ContentMatchHook{}, fmt.Errorf("magic must be hex bytes, like 25504446, not %s", magicStr)
+				}
+
+				hook.magics = append(hook.magics, magic)
+			}
+		case "extension":
+			for _, extension := range splitList(value) {
+				hook.extensions = append(hook.extensions, "."+strings.ToLower(strings.TrimPrefix(extension, ".")))
+			}
+		case "min_size":
+			hook.minSize, err = parseByteSize(value)
+		case "max_size":
+			hook.maxSize, err = parseByteSize(value)
+		case "content_regex":
+			hook.contentRegex, err = regexp.Compile(value)
+		case "content_bytes":
+			hook.contentBytes, err = strconv.Atoi(value)
+
+			if err == nil && (hook.contentBytes <= 0 || hook.contentBytes > DataSampleSize) {
+				err = fmt.Errorf("must be between 1 and %d", DataSampleSize)
+			}
+		default:
+			return ContentMatchHook{}, fmt.Errorf("unknown key %s", key)
+		}
+
+		if err != nil {
+			return ContentMatchHook{}, fmt.Errorf("invalid %s: %s", key, err.Error())
+		}
+	}
+
+	if len(properties) == 0 {
+		return ContentMatchHook{}, fmt.Errorf("no conditions")
+	}
+
+	if hook.maxSize >= 0 && hook.maxSize < hook.minSize {
+		return ContentMatchHook{}, fmt.Errorf("max_size is less than min_size")
+	}
+
+	return hook, nil
+}
+
+// matchesHead checks the conditions that only depend on the start of the
+// data file: the MIME type, magic bytes, and content regex.
+func (hook ContentMatchHook) matchesHead(head []byte) bool {
+	if len(hook.mimeTypes) > 0 {
+		mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+
+		if err != nil || !matchesAny(hook.mimeTypes, mimeType) {
+			return false
+		}
+	}
+
+	if len(hook.magics) > 0 {
+		matched := false
+
+		for _, magic := range hook.magics {
+			if len(head) >= len(magic) && string(head[:len(magic)]) == string(magic) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if hook.contentRegex != nil {
+		if len(head) > hook.contentBytes {
+			head = head[:hook.contentBytes]
+		}
+
+		if !hook.contentRegex.Match(head) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (hook ContentMatchHook) MatchesData(sample DataSample) bool {
+	if !sample.Valid {
+		return false
+	}
+
+	if len(hook.extensions) > 0 {
+		name := strings.ToLower(sample.Name)
+		matched := false
+
+		for _, extension := range hook.extensions {
+			if strings.HasSuffix(name, extension) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if sample.Size < hook.minSize || (hook.maxSize >= 0 && sample.Size > hook.maxSize) {
+		return false
+	}
+
+	return hook.matchesHead(sample.Head)
+}
+
+func matchesAny(globs []string, value string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// parseByteSize reads sizes like 512, 64K, 1.5M, or 2GB, where the units
+// are powers of 1024.
+func parseByteSize(sizeStr string) (int64, error) {
+	numberStr := strings.ToUpper(strings.TrimSpace(sizeStr))
+	numberStr = strings.TrimSuffix(strings.TrimSuffix(numberStr, "B"), "I")
+	multiplier := float64(1)
+
+	if i := strings.IndexAny(numberStr, "KMGT"); i >= 0 && i == len(numberStr)-1 {
+		multiplier = float64(int64(1) << (10 * uint(strings.IndexByte("KMGT", numberStr[i])+1)))
+		numberStr = numberStr[:i]
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(numberStr), 64)
+
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("%s is not a size, like 512, 64K, or 1.5M", sizeStr)
+	}
+
+	return int64(number * multiplier), nil
+}
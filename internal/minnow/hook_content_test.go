@@ -0,0 +1,155 @@
+package minnow
+
+import (
+	"bytes"
+	"testing"
+)
+
+func makeTestDataFile(t *testing.T, name string, content []byte) DataSample {
+	dir := Path("/tmp/minnow-data-" + randomString(20))
+	dir.Mkdir()
+	dataPath := dir.JoinPath(Path(name))
+	err := dataPath.WriteBytes(content)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	sample, err := ReadDataSample(dataPath)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return sample
+}
+
+func TestContentMatchHookLargePDF(t *testing.T) {
+	hook, err := NewContentMatchHookFromFile(makeTestHookFile(t, "mime_type = application/pdf\nmin_size = 1M"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	pdfHead := []byte("%PDF-1.5\n%\xe2\xe3\xcf\xd3\n")
+	largePDF := makeTestDataFile(t, "scan", append(pdfHead, make([]byte, 1024*1024)...))
+	smallPDF := makeTestDataFile(t, "scan.pdf", pdfHead)
+	largeText := makeTestDataFile(t, "notes.pdf", bytes.Repeat([]byte("not a pdf "), 200*1024))
+
+	if len(largePDF.Head) != DataSampleSize {
+		t.Errorf("Read %d bytes of the data, expected %d", len(largePDF.Head), DataSampleSize)
+	}
+
+	tests := map[*DataSample]bool{&largePDF: true, &smallPDF: false, &largeText: false, &DataSample{}: false}
+
+	for sample, expected := range tests {
+		if hook.MatchesData(*sample) != expected {
+			t.Errorf("Hook should have returned %t for %s", expected, sample.Name)
+		}
+	}
+
+	// a content hook can't be loaded as one that only sees metadata
+	if _, err := NewHookFromFile(ContentMatchHookType, makeTestHookFile(t, "mime_type = application/pdf")); err == nil {
+		t.Errorf("Content hook should not load as a metadata hook")
+	}
+}
+
+func TestContentMatchHookConditions(t *testing.T) {
+	sample := makeTestDataFile(t, "Readings.CSV", []byte("site,temp\nnorth,21.5\n"))
+	tests := map[string]bool{
+		"extension = csv":                               true,
+		"extension = .tsv, .csv":                        true,
+		"extension = tsv":                               false,
+		"mime_type = text/*":                            true,
+		"max_size = 1K":                                 true,
+		"max_size = 10":                                 false,
+		"magic = 736974652c":                            true,
+		"magic = 25504446":                              false,
+		"content_regex = ^site,temp":                    true,
+		"content_regex = north\ncontent_bytes = 10":     false,
+		"content_regex = north\ncontent_bytes = 20":     true,
+		"extension = csv\ncontent_regex = ^temperature": false,
+	}
+
+	for content, expected := range tests {
+		hook, err := NewContentMatchHookFromFile(makeTestHookFile(t, content))
+
+		if err != nil {
+			t.Errorf("Could not load %q: %s", content, err.Error())
+			continue
+		}
+
+		if hook.MatchesData(sample) != expected {
+			t.Errorf("%q should have returned %t", content, expected)
+		}
+	}
+}
+
+func TestDataSamplerIsLazy(t *testing.T) {
+	metadataHook, err := LoadHook("metadata", BasicPropertiesMatchHookType, makeTestHookFile(t, "type = test"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	contentHook, err := LoadHook("content", ContentMatchHookType, makeTestHookFile(t, "max_size = 1K"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	sampler := NewDataSampler(Path("/tmp/minnow-data-" + randomString(20)))
+	hooks := Hooks{metadataHook, contentHook}
+
+	if hookName, _ := hooks.Match(Properties{"type": "test"}, sampler); hookName != "metadata" || sampler.sample != nil {
+		t.Errorf("Data should not be read when a metadata hook matches first")
+	}
+
+	if _, matched := hooks.Match(Properties{"type": "other"}, sampler); matched || sampler.Err() == nil {
+		t.Errorf("Content hook should have tried to read the missing data")
+	}
+}
+
+func TestInvalidContentMatchHooks(t *testing.T) {
+	tests := []string{
+		"",
+		"colour = red",
+		"min_size = big",
+		"min_size = 2M\nmax_size = 1M",
+		"magic = %PDF",
+		"content_regex = (",
+		"content_bytes = 100000",
+		"mime_type = [image",
+	}
+
+	for _, content := range tests {
+		if _, err := NewContentMatchHookFromFile(makeTestHookFile(t, content)); err == nil {
+			t.Errorf("%q should not have loaded", content)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"512":   512,
+		"64K":   64 * 1024,
+		"1.5M":  1024 * 1024 * 3 / 2,
+		"2GB":   2 * 1024 * 1024 * 1024,
+		"1 MiB": 1024 * 1024,
+		"1t":    1024 * 1024 * 1024 * 1024,
+	}
+
+	for sizeStr, expected := range tests {
+		size, err := parseByteSize(sizeStr)
+
+		if err != nil || size != expected {
+			t.Errorf("%s should be %d bytes, got %d", sizeStr, expected, size)
+		}
+	}
+
+	for _, sizeStr := range []string{"", "M", "-1", "1X", "K1"} {
+		if _, err := parseByteSize(sizeStr); err == nil {
+			t.Errorf("%q should not have parsed", sizeStr)
+		}
+	}
+}
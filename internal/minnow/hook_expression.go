@@ -0,0 +1,621 @@
+package minnow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ExpressionHook matches metadata against a boolean expression, like:
+//
+//	type = blueprints and orientation in [above, front] and not draft exists
+//
+// The expression is parsed when the hook is loaded, so a processor with a
+// broken hook fails to load rather than never matching.
+type ExpressionHook struct {
+	root exprNode
+}
+
+func NewExpressionHookFromFile(path Path) (ExpressionHook, error) {
+	input, err := path.ReadBytes()
+
+	if err != nil {
+		return ExpressionHook{}, err
+	}
+
+	return newExpressionHook(path, input)
+}
+
+func newExpressionHook(path Path, input []byte) (ExpressionHook, error) {
+	root, err := parseExpression(string(input))
+
+	if err != nil {
+		return ExpressionHook{}, fmt.Errorf("Invalid expression in %s: %s", path, err.Error())
+	}
+
+	return ExpressionHook{root}, nil
+}
+
+func (hook ExpressionHook) Matches(matchAgainst Properties) bool {
+	return hook.root.eval(matchAgainst)
+}
+
+func (hook ExpressionHook) MatchesBytes(matchAgainst []byte) bool {
+	properties, err := BytesToProperties(matchAgainst)
+
+	if err != nil {
+		return false
+	}
+
+	return hook.Matches(properties)
+}
+
+// ExpressionError points at the part of an expression that couldn't be
+// parsed.
+type ExpressionError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (err *ExpressionError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", err.Line, err.Column, err.Message)
+}
+
+//
+// AST
+//
+
+type exprNode interface {
+	eval(Properties) bool
+}
+
+type andNode struct {
+	left, right exprNode
+}
+
+func (node andNode) eval(properties Properties) bool {
+	return node.left.eval(properties) && node.right.eval(properties)
+}
+
+type orNode struct {
+	left, right exprNode
+}
+
+func (node orNode) eval(properties Properties) bool {
+	return node.left.eval(properties) || node.right.eval(properties)
+}
+
+type notNode struct {
+	operand exprNode
+}
+
+func (node notNode) eval(properties Properties) bool {
+	return !node.operand.eval(properties)
+}
+
+type existsNode struct {
+	key string
+}
+
+func (node existsNode) eval(properties Properties) bool {
+	_, found := properties[node.key]
+	return found
+}
+
+type inNode struct {
+	key    string
+	values []string
+}
+
+func (node inNode) eval(properties Properties) bool {
+	value, found := properties[node.key]
+
+	if !found {
+		return false
+	}
+
+	for _, candidate := range node.values {
+		if value == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compareNode compares a key's value with a literal.  Equality compares
+// strings, and ordering parses the key's value as the literal's type.  A
+// comparison with a missing key is always false, even for !=.
+type compareNode struct {
+	key       string
+	operator  string
+	value     string
+	valueType *exprType // nil for equality
+	typed     typedValue
+}
+
+func (node compareNode) eval(properties Properties) bool {
+	value, found := properties[node.key]
+
+	if !found {
+		return false
+	}
+
+	switch node.operator {
+	case "=", "==":
+		return value == node.value
+	case "!=":
+		return value != node.value
+	}
+
+	typed, err := node.valueType.parse(value)
+
+	if err != nil {
+		if _, versionErr := exprVersionType.parse(value); versionErr == nil && node.valueType != exprVersionType {
+			debugLogger.Printf("%s doesn't match because %s = %s is not a %s; write version(%s) to compare versions", node, node.key, value, node.valueType.name, node.value)
+		} else {
+			debugLogger.Printf("%s doesn't match because %s = %s is not a %s", node, node.key, value, node.valueType.name)
+		}
+
+		return false
+	}
+
+	c := node.valueType.compare(typed, node.typed)
+
+	switch node.operator {
+	case "<", "before":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">", "after":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false
+}
+
+func (node compareNode) String() string {
+	return fmt.Sprintf("%s %s %s", node.key, node.operator, node.value)
+}
+
+//
+// lexer
+//
+
+type exprTokenKind int
+
+const (
+	exprTokenEnd exprTokenKind = iota
+	exprTokenWord
+	exprTokenString
+	exprTokenOperator
+	exprTokenPunctuation
+)
+
+type exprToken struct {
+	kind   exprTokenKind
+	text   string
+	line   int
+	column int
+}
+
+func (token exprToken) String() string {
+	switch token.kind {
+	case exprTokenEnd:
+		return "end of expression"
+	case exprTokenString:
+		return strconv.Quote(token.text)
+	}
+
+	return "'" + token.text + "'"
+}
+
+// characters that end a bare word
+const exprDelimiters = "()[],\"=!<>#"
+
+func lexExpression(input string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	runes := []rune(input)
+	line, column := 1, 1
+
+	advance := func() {
+		if runes[0] == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+
+		runes = runes[1:]
+	}
+
+	for len(runes) > 0 {
+		r := runes[0]
+		start := exprToken{line: line, column: column}
+
+		switch {
+		case unicode.IsSpace(r):
+			advance()
+		case r == '#':
+			// comments run to the end of the line
+			for len(runes) > 0 && runes[0] != '\n' {
+				advance()
+			}
+		case strings.ContainsRune("()[],", r):
+			start.kind, start.text = exprTokenPunctuation, string(r)
+			tokens = append(tokens, start)
+			advance()
+		case strings.ContainsRune("=!<>", r):
+			advance()
+			text := string(r)
+
+			if len(runes) > 0 && runes[0] == '=' {
+				text += "="
+				advance()
+			}
+
+			if text == "!" {
+				return nil, &ExpressionError{start.line, start.column, "expected != but found !"}
+			}
+
+			start.kind, start.text = exprTokenOperator, text
+			tokens = append(tokens, start)
+		case r == '"':
+			advance()
+			var text strings.Builder
+
+			for {
+				if len(runes) == 0 || runes[0] == '\n' {
+					return nil, &ExpressionError{start.line, start.column, "unterminated string"}
+				}
+
+				if runes[0] == '"' {
+					advance()
+					break
+				}
+
+				if runes[0] == '\\' && len(runes) > 1 {
+					advance()
+				}
+
+				text.WriteRune(runes[0])
+				advance()
+			}
+
+			start.kind, start.text = exprTokenString, text.String()
+			tokens = append(tokens, start)
+		default:
+			var text strings.Builder
+
+			for len(runes) > 0 && !unicode.IsSpace(runes[0]) && !strings.ContainsRune(exprDelimiters, runes[0]) {
+				text.WriteRune(runes[0])
+				advance()
+			}
+
+			start.kind, start.text = exprTokenWord, text.String()
+			tokens = append(tokens, start)
+		}
+	}
+
+	tokens = append(tokens, exprToken{exprTokenEnd, "", line, column})
+	return tokens, nil
+}
+
+//
+// parser
+//
+
+var exprKeywords = map[string]bool{
+	"and":     true,
+	"or":      true,
+	"not":     true,
+	"in":      true,
+	"exists":  true,
+	"missing": true,
+	"before":  true,
+	"after":   true,
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// parseExpression parses a hook expression:
+//
+//	expression := and { "or" and }
+//	and        := not { "and" not }
+//	not        := "not" not | primary
+//	primary    := "(" expression ")" | key test
+//	test       := operator value | "in" "[" value { "," value } "]" | "exists" | "missing"
+//	operator   := "=" | "==" | "!=" | "<" | "<=" | ">" | ">=" | "before" | "after"
+//
+// After <, <=, >, and >=, the value can also be a typed literal, like
+// version(2.10), which is read as that type instead of the first one it
+// parses as.
+//
+// Keys and values are bare words or double quoted strings, and everything
+// after a # on a line is a comment.
+func parseExpression(input string) (exprNode, error) {
+	tokens, err := lexExpression(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &exprParser{tokens, 0}
+
+	if parser.peek().kind == exprTokenEnd {
+		return nil, parser.errorAt(parser.peek(), "expression is empty")
+	}
+
+	root, err := parser.parseOr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token := parser.peek(); token.kind != exprTokenEnd {
+		return nil, parser.errorAt(token, fmt.Sprintf("expected and, or, or end of expression, found %s", token))
+	}
+
+	return root, nil
+}
+
+func (parser *exprParser) peek() exprToken {
+	return parser.tokens[parser.pos]
+}
+
+func (parser *exprParser) next() exprToken {
+	token := parser.tokens[parser.pos]
+
+	if token.kind != exprTokenEnd {
+		parser.pos++
+	}
+
+	return token
+}
+
+func (parser *exprParser) errorAt(token exprToken, message string) error {
+	return &ExpressionError{token.line, token.column, message}
+}
+
+func (parser *exprParser) isKeyword(token exprToken, keyword string) bool {
+	return token.kind == exprTokenWord && token.text == keyword
+}
+
+func (parser *exprParser) expectPunctuation(punctuation string) error {
+	token := parser.next()
+
+	if token.kind != exprTokenPunctuation || token.text != punctuation {
+		return parser.errorAt(token, fmt.Sprintf("expected '%s', found %s", punctuation, token))
+	}
+
+	return nil
+}
+
+func (parser *exprParser) parseOr() (exprNode, error) {
+	left, err := parser.parseAnd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.isKeyword(parser.peek(), "or") {
+		parser.next()
+		right, err := parser.parseAnd()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (parser *exprParser) parseAnd() (exprNode, error) {
+	left, err := parser.parseNot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.isKeyword(parser.peek(), "and") {
+		parser.next()
+		right, err := parser.parseNot()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = andNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (parser *exprParser) parseNot() (exprNode, error) {
+	if parser.isKeyword(parser.peek(), "not") {
+		parser.next()
+		operand, err := parser.parseNot()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return notNode{operand}, nil
+	}
+
+	return parser.parsePrimary()
+}
+
+func (parser *exprParser) parsePrimary() (exprNode, error) {
+	token := parser.next()
+
+	if token.kind == exprTokenPunctuation && token.text == "(" {
+		node, err := parser.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return node, parser.expectPunctuation(")")
+	}
+
+	if (token.kind != exprTokenWord && token.kind != exprTokenString) || (token.kind == exprTokenWord && exprKeywords[token.text]) {
+		return nil, parser.errorAt(token, fmt.Sprintf("expected a key or '(', found %s", token))
+	}
+
+	key := token.text
+	token = parser.next()
+
+	switch {
+	case parser.isKeyword(token, "exists"):
+		return existsNode{key}, nil
+	case parser.isKeyword(token, "missing"):
+		return notNode{existsNode{key}}, nil
+	case parser.isKeyword(token, "in"):
+		return parser.parseIn(key)
+	case token.kind == exprTokenOperator, parser.isKeyword(token, "before"), parser.isKeyword(token, "after"):
+		return parser.parseComparison(key, token)
+	}
+
+	return nil, parser.errorAt(token, fmt.Sprintf("expected an operator, in, exists, or missing after %s, found %s", key, token))
+}
+
+func (parser *exprParser) parseValue() (exprToken, error) {
+	token := parser.next()
+
+	if token.kind != exprTokenWord && token.kind != exprTokenString {
+		return token, parser.errorAt(token, fmt.Sprintf("expected a value, found %s", token))
+	}
+
+	return token, nil
+}
+
+func (parser *exprParser) parseIn(key string) (exprNode, error) {
+	err := parser.expectPunctuation("[")
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0)
+
+	for {
+		value, err := parser.parseValue()
+
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value.text)
+		token := parser.next()
+
+		if token.kind == exprTokenPunctuation && token.text == "]" {
+			return inNode{key, values}, nil
+		}
+
+		if token.kind != exprTokenPunctuation || token.text != "," {
+			return nil, parser.errorAt(token, fmt.Sprintf("expected ',' or ']', found %s", token))
+		}
+	}
+}
+
+func (parser *exprParser) parseComparison(key string, operator exprToken) (exprNode, error) {
+	if isOrdering(operator) {
+		if valueType := parser.peekTypedLiteral(); valueType != nil {
+			return parser.parseTypedComparison(key, operator, valueType)
+		}
+	}
+
+	value, err := parser.parseValue()
+
+	if err != nil {
+		return nil, err
+	}
+
+	node := compareNode{key, operator.text, value.text, nil, typedValue{}}
+
+	switch operator.text {
+	case "=", "==", "!=":
+		return node, nil
+	case "before", "after":
+		node.valueType = exprTimestampType
+		node.typed, err = exprTimestampType.parse(value.text)
+
+		if err != nil {
+			return nil, parser.errorAt(value, fmt.Sprintf("%s needs a timestamp, found %s", operator.text, value))
+		}
+
+		return node, nil
+	}
+
+	var found bool
+	node.valueType, node.typed, found = literalType(value.text)
+
+	if !found {
+		return nil, parser.errorAt(value, fmt.Sprintf("%s needs a number, timestamp, duration, or version, found %s", operator.text, value))
+	}
+
+	return node, nil
+}
+
+func isOrdering(operator exprToken) bool {
+	switch operator.text {
+	case "<", "<=", ">", ">=":
+		return operator.kind == exprTokenOperator
+	}
+
+	return false
+}
+
+// peekTypedLiteral returns the type named by a literal like version(2.10),
+// which is needed when the literal would otherwise be read as another type.
+func (parser *exprParser) peekTypedLiteral() *exprType {
+	if parser.pos+1 >= len(parser.tokens) {
+		return nil
+	}
+
+	name, open := parser.tokens[parser.pos], parser.tokens[parser.pos+1]
+
+	if name.kind != exprTokenWord || open.kind != exprTokenPunctuation || open.text != "(" {
+		return nil
+	}
+
+	for _, valueType := range exprTypes {
+		if valueType.name == name.text {
+			return valueType
+		}
+	}
+
+	return nil
+}
+
+func (parser *exprParser) parseTypedComparison(key string, operator exprToken, valueType *exprType) (exprNode, error) {
+	parser.next()
+	parser.next()
+	value, err := parser.parseValue()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parser.expectPunctuation(")"); err != nil {
+		return nil, err
+	}
+
+	typed, err := valueType.parse(value.text)
+
+	if err != nil {
+		return nil, parser.errorAt(value, fmt.Sprintf("%s is not a %s", value, valueType.name))
+	}
+
+	return compareNode{key, operator.text, value.text, valueType, typed}, nil
+}
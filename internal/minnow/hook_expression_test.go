@@ -0,0 +1,100 @@
+package minnow
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExpressionHook(t *testing.T) {
+	hookPath := makeTestHookFile(t, `
+		# blueprints that are ready for review
+		type = blueprints
+		and (orientation = above or orientation == front)
+		and not draft exists`)
+	hook, err := NewHookFromFile(ExpressionHookType, hookPath)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tests := map[string]bool{
+		"type = blueprints\norientation = above":              true,
+		"type = blueprints\norientation = front\nsize = huge": true,
+		"type = blueprints\norientation = side":               false,
+		"type = blueprints\norientation = above\ndraft = yes": false,
+		"type = drawings\norientation = above":                false,
+	}
+
+	for metadata, expected := range tests {
+		if hook.MatchesBytes([]byte(metadata)) != expected {
+			t.Errorf("Hook should have returned %t for %q", expected, metadata)
+		}
+	}
+}
+
+func TestExpressionEval(t *testing.T) {
+	metadata := Properties{"type": "blueprints", "size_mb": "150", "site": "north 1", "status": "ok"}
+	tests := map[string]bool{
+		`size_mb > 100`:                                        true,
+		`size_mb >= 150 and size_mb <= 150`:                    true,
+		`size_mb < 100`:                                        false,
+		`size_mb > 100.5`:                                      true,
+		`type > 100`:                                           false, // not a number
+		`type in [drawings, blueprints]`:                       true,
+		`type in [drawings]`:                                   false,
+		`site = "north 1"`:                                     true,
+		`missing_key != foo`:                                   false,
+		`missing_key missing`:                                  true,
+		`type missing or status = ok`:                          true,
+		`not not type exists`:                                  true,
+		`type = blueprints and site = x or status = failed`:    false,
+		`(type = x or type = blueprints) and status != failed`: true,
+		`status = "missing" or status = ok`:                    true,
+	}
+
+	for expression, expected := range tests {
+		root, err := parseExpression(expression)
+
+		if err != nil {
+			t.Errorf("Could not parse %q: %s", expression, err.Error())
+			continue
+		}
+
+		if root.eval(metadata) != expected {
+			t.Errorf("%q should have returned %t", expression, expected)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := map[string][2]int{
+		"":                          {1, 1},
+		"type = blueprints and":     {1, 22},
+		"type = blueprints\nand (":  {2, 6},
+		"type blueprints":           {1, 6},
+		"size_mb > big":             {1, 11},
+		"type in [a, b":             {1, 14},
+		"type = \"unterminated":     {1, 8},
+		"type ! foo":                {1, 6},
+		"(type = foo":               {1, 12},
+		"type = foo bar":            {1, 12},
+		"  # comment\n  and = foo":  {2, 3},
+		"type in [a,\n  , b]":       {2, 3},
+		"type = blueprints )":       {1, 19},
+		"type = foo\n\n  or size <": {3, 12},
+	}
+
+	for expression, position := range tests {
+		_, err := parseExpression(expression)
+		var expressionErr *ExpressionError
+
+		if !errors.As(err, &expressionErr) {
+			t.Errorf("%q should not have parsed", expression)
+			continue
+		}
+
+		if expressionErr.Line != position[0] || expressionErr.Column != position[1] {
+			t.Errorf("%q failed at %s, expected line %d, column %d", expression, err.Error(), position[0], position[1])
+		}
+	}
+}
@@ -43,3 +43,126 @@ func TestBasicPropertiesMatchHookNoMatch(t *testing.T) {
 	}
 
 }
+
+func makeTestHookFile(t *testing.T, content string) Path {
+	hookPath := Path("/tmp/minnow-hook-" + randomString(20) + ".properties")
+	err := hookPath.WriteBytes([]byte(content))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return hookPath
+}
+
+func TestGlobMatchHook(t *testing.T) {
+	hook, err := NewHookFromFile(GlobMatchHookType, makeTestHookFile(t, "filename = *.csv\nsite = north-?"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tests := map[string]bool{
+		"filename = readings.csv\nsite = north-1":     true,
+		"filename = readings.csv.gz\nsite = north-1":  false,
+		"filename = readings.csv\nsite = north-12":    false,
+		"filename = readings.csv":                     false,
+		"filename = a.csv\nsite = north-2\nextra = 1": true,
+	}
+
+	for metadata, expected := range tests {
+		if hook.MatchesBytes([]byte(metadata)) != expected {
+			t.Errorf("Hook should have returned %t for %q", expected, metadata)
+		}
+	}
+}
+
+func TestGlobMatchHookValues(t *testing.T) {
+	hook, err := NewHookFromFile(GlobMatchHookType, makeTestHookFile(t, "source = *\nname = report\\*\\?-[!0-9]"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tests := map[string]bool{
+		"source = a/b\nname = report*?-x":      true,
+		"source = /data/in\nname = report*?-x": true,
+		"source = a/b\nname = reports?-x":      false,
+		"source = a/b\nname = report*a-x":      false,
+		"source = a/b\nname = report*?-1":      false,
+	}
+
+	for metadata, expected := range tests {
+		if hook.MatchesBytes([]byte(metadata)) != expected {
+			t.Errorf("Hook should have returned %t for %q", expected, metadata)
+		}
+	}
+}
+
+func TestRegexMatchHook(t *testing.T) {
+	hook, err := NewHookFromFile(RegexMatchHookType, makeTestHookFile(t, "sensor = ^temp-[0-9]+$\nunit = C"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tests := map[string]bool{
+		"sensor = temp-42\nunit = C":       true,
+		"sensor = temp-42a\nunit = C":      false,
+		"sensor = temp-42\nunit = Celsius": true, // unanchored
+		"sensor = temp-42":                 false,
+	}
+
+	for metadata, expected := range tests {
+		if hook.MatchesBytes([]byte(metadata)) != expected {
+			t.Errorf("Hook should have returned %t for %q", expected, metadata)
+		}
+	}
+}
+
+func TestInvalidHookPatterns(t *testing.T) {
+	tests := map[string]string{
+		RegexMatchHookType: "sensor = temp-(",
+		GlobMatchHookType:  "filename = [abc",
+	}
+
+	for hookType, content := range tests {
+		if _, err := NewHookFromFile(hookType, makeTestHookFile(t, content)); err == nil {
+			t.Errorf("%s should not have loaded %q", hookType, content)
+		}
+	}
+
+	if _, err := NewHookFromFile(GlobMatchHookType, makeTestHookFile(t, "filename = abc\\")); err == nil {
+		t.Errorf("A glob ending in \\ should not have loaded")
+	}
+
+	if _, err := NewHookFromFile("nosuchhook", makeTestHookFile(t, "type = foo")); err == nil {
+		t.Errorf("Unknown hook type should not have loaded")
+	}
+}
+
+func TestLoadHookType(t *testing.T) {
+	tests := map[string]bool{
+		"hook_type = expressionhook\nsize > 10 and type = test": true,
+		"\nhook_type=globmatchhook\ntype = t*":                  true,
+		"type = test":                                           true, // the default
+		"hook_type = regexmatchhook\ntype = ^x$":                false,
+	}
+
+	for content, expected := range tests {
+		hook, err := LoadHook("hook", BasicPropertiesMatchHookType, makeTestHookFile(t, content))
+
+		if err != nil {
+			t.Errorf("Could not load %q: %s", content, err.Error())
+			continue
+		}
+
+		if hook.Hook.Matches(Properties{"size": "20", "type": "test"}) != expected {
+			t.Errorf("%q should have returned %t", content, expected)
+		}
+	}
+
+	if _, err := LoadHook("hook", BasicPropertiesMatchHookType, makeTestHookFile(t, "hook_type = nosuchhook\ntype = test")); err == nil {
+		t.Errorf("Unknown hook_type in a hook file should not have loaded")
+	}
+}
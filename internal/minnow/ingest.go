@@ -1,109 +1,749 @@
 package minnow
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
+const (
+	IngestCompletionAge      = "age"
+	IngestCompletionSentinel = "sentinel"
+
+	// metadata key holding the hex encoded SHA-256 of the data file
+	ChecksumKey = "minnow_sha256"
+
+	// how often to check for room while ingest is paused
+	ingestPauseInterval = time.Duration(1) * time.Second
+)
+
 type IngestDirInfo struct {
 	IngestPath         Path
 	MinAge             time.Duration
 	ProcessedBy        []ProcessorId
 	RemoveOnceIngested bool
+	MetadataName       string // if set, only this pair is ingested, without waiting for it to age.  Relative to IngestPath.
+}
+
+// SentinelExtensions are the extensions of the marker files that say a
+// data file is complete, when ingest_completion is sentinel.
+var SentinelExtensions = []string{".ready", ".done"}
+
+// sentinelPaths returns the marker files for dataPath that exist.
+func sentinelPaths(dataPath Path) []Path {
+	paths := make([]Path, 0)
+
+	for _, extension := range SentinelExtensions {
+		path := Path(string(dataPath) + extension)
+
+		if path.IsFile() {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// isSentinel returns true if path is named like a marker file.
+func isSentinel(path Path) bool {
+	for _, extension := range SentinelExtensions {
+		if strings.HasSuffix(string(path), extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checksumMismatch checks a data file against the SHA-256 in its metadata,
+// if there is one.  Returns why they don't match, or an empty string if
+// they do.
+func checksumMismatch(metadataPath, dataPath Path) (string, error) {
+	properties, err := PropertiesFromFile(metadataPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	expected, found := properties[ChecksumKey]
+
+	if !found {
+		return "", nil
+	}
+
+	actual, err := dataPath.SHA256()
+
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(expected), actual) {
+		return fmt.Sprintf("SHA-256 of %s is %s, but %s is %s", dataPath.Name(), actual, ChecksumKey, expected), nil
+	}
+
+	return "", nil
+}
+
+// IngestSettings are the parts of the config that decide how new data is
+// ingested.
+type IngestSettings struct {
+	Sources       []IngestSource
+	Rules         *IngestRules // nil if there aren't any
+	Completion    string
+	OrphanTimeout time.Duration // zero leaves orphans where they are
+}
+
+// orphan is a file in an ingest directory that isn't part of a complete
+// pair, along with anything that goes with it.
+type orphan struct {
+	paths     []Path
+	reason    string
+	firstSeen time.Time
 }
 
 type DirectoryIngester struct {
-	workPath      Path
-	ingestDirChan chan IngestDirInfo
-	dispatchChan  chan DispatchInfo
-	logger        *log.Logger
+	workPath          Path
+	ingestDirQueue    *IngestDirQueue
+	dispatchQueue     *DispatchQueue
+	processorRegistry *ProcessorRegistry
+	quarantine        *Quarantine
+	rejectedDir       *RejectedDir
+	settings          IngestSettings
+	sources           map[Path]IngestSource
+	sizes             map[Path]int64   // file sizes seen by the last scan
+	orphans           map[Path]*orphan // keyed by the orphan's first path
+	logger            *log.Logger
 }
 
-func NewDirectoryIngester(workPath Path, ingestDirChan chan IngestDirInfo, dispatchChan chan DispatchInfo) *DirectoryIngester {
+func NewDirectoryIngester(workPath Path, ingestDirQueue *IngestDirQueue, dispatchQueue *DispatchQueue, processorRegistry *ProcessorRegistry, quarantine *Quarantine, rejectedDir *RejectedDir, settings IngestSettings) *DirectoryIngester {
 	logger := log.New(os.Stdout, "DirectoryIngester: ", 0)
-	return &DirectoryIngester{workPath, ingestDirChan, dispatchChan, logger}
+	sources := make(map[Path]IngestSource)
+
+	for _, source := range settings.Sources {
+		sources[source.Path] = source
+	}
+
+	return &DirectoryIngester{
+		workPath,
+		ingestDirQueue,
+		dispatchQueue,
+		processorRegistry,
+		quarantine,
+		rejectedDir,
+		settings,
+		sources,
+		make(map[Path]int64),
+		make(map[Path]*orphan),
+		logger,
+	}
 }
 
-func moveToRandomPath(workPath, metadataPath, dataPath Path) (Path, Path, error) {
+// moveToRandomPath moves a pair into a new directory in the work path.  If
+// merge is given, the metadata is written through it on the way, and the
+// metadata file doesn't have to exist.
+func moveToRandomPath(workPath, metadataPath, dataPath Path, processedBy []ProcessorId, merge func(Properties) Properties) (Path, Path, error) {
 	randomPath, err := makeRandomPath(workPath, "dispatch")
 
 	if err != nil {
 		return metadataPath, dataPath, err
 	}
 
-	newMetadataPath := randomPath.JoinPath(Path(metadataPath.Name()))
-	err = metadataPath.Rename(newMetadataPath)
+	// Record the lineage before moving anything, so the dispatch can be
+	// recovered if minnow dies before it's finished.
+	err = WriteWorkState(randomPath, WorkState{Kind: WorkKindDispatch, ProcessedBy: processedBy})
 
 	if err != nil {
 		return metadataPath, dataPath, err
 	}
 
+	newMetadataPath := randomPath.JoinPath(Path(metadataPath.Name()))
 	newDataPath := randomPath.JoinPath(Path(dataPath.Name()))
-	err = dataPath.Rename(newDataPath)
+
+	if merge == nil {
+		err = metadataPath.Move(newMetadataPath)
+
+		if err != nil {
+			return metadataPath, dataPath, err
+		}
+
+		err = dataPath.Move(newDataPath)
+
+		if err != nil {
+			return metadataPath, dataPath, err
+		}
+
+		return newMetadataPath, newDataPath, nil
+	}
+
+	// The merged metadata is written before the data is moved, and the
+	// original is only removed after, so the pair is never split up
+	// without a complete copy somewhere.
+	metadata := make(Properties)
+
+	if metadataPath.Exists() {
+		metadata, err = PropertiesFromFile(metadataPath)
+
+		if err != nil {
+			return metadataPath, dataPath, err
+		}
+	}
+
+	err = newMetadataPath.WriteBytes(merge(metadata).ToBytes())
+
+	if err != nil {
+		return metadataPath, dataPath, err
+	}
+
+	err = dataPath.Move(newDataPath)
 
 	if err != nil {
 		return metadataPath, dataPath, err
 	}
 
+	// The pair has been moved at this point, so a leftover original is
+	// just reported by later scans as missing its data file.
+	if metadataPath.Exists() {
+		metadataPath.Unlink()
+	}
+
 	return newMetadataPath, newDataPath, nil
 }
 
 func (ingester *DirectoryIngester) Run() {
-	for ingestDirInfo := range ingester.ingestDirChan {
-		metadataPaths, _ := ingestDirInfo.IngestPath.Glob("*" + PropertiesExtension)
+	for {
+		ingestDirInfo, entry, err := ingester.ingestDirQueue.Take(nil)
+
+		if err != nil {
+			ingester.logger.Printf("Discarding ingest entry: %s", err.Error())
+		} else if !ingester.ingest(ingestDirInfo) {
+			ingester.resumeLater(ingestDirInfo)
+		}
+
+		err = ingester.ingestDirQueue.Ack(entry)
+
+		if err != nil {
+			ingester.logger.Print(err.Error())
+		}
+	}
+}
+
+// resumeLater picks a paused scan back up once everything queued in the
+// meantime, like processor output, has had a chance to go first.  A paused
+// ingest of a single pair is dropped, since the next scan finds it anyway.
+func (ingester *DirectoryIngester) resumeLater(ingestDirInfo IngestDirInfo) {
+	if len(ingestDirInfo.MetadataName) > 0 {
+		return
+	}
+
+	time.AfterFunc(ingestPauseInterval, func() {
+		// the ticker may have queued a scan of its own by now
+		if ingester.ingestDirQueue.ScanQueued(ingestDirInfo.IngestPath) {
+			return
+		}
+
+		err := ingester.ingestDirQueue.Put(ingestDirInfo)
+
+		if err != nil {
+			ingester.logger.Printf("Could not queue scan of %s: %s", ingestDirInfo.IngestPath, err.Error())
+		}
+	})
+}
+
+// saturated returns true if the dispatch queue or any processor's queue is
+// full.  New data is held back while saturated, but work that is already
+// inside minnow is always queued, so the ingest, dispatch, and run loop
+// can't deadlock.
+func (ingester *DirectoryIngester) saturated() bool {
+	return ingester.dispatchQueue.Full() || ingester.processorRegistry.Saturated()
+}
+
+// sizeSettled records the sizes of the given files, and returns true if
+// none of them have changed size since the last scan.  Age alone isn't
+// enough, since a copy that preserves modification times looks old while
+// it's still being written.
+func (ingester *DirectoryIngester) sizeSettled(paths ...Path) bool {
+	settled := true
+
+	for _, path := range paths {
+		size, err := path.Size()
+
+		if err != nil {
+			return false
+		}
+
+		lastSize, found := ingester.sizes[path]
+		ingester.sizes[path] = size
+
+		if !found || size != lastSize {
+			settled = false
+		}
+	}
+
+	return settled
+}
+
+// forgetSizes drops the recorded sizes of files under dir that the last
+// scan didn't see.
+func (ingester *DirectoryIngester) forgetSizes(dir Path, seen map[Path]bool) {
+	for path := range ingester.sizes {
+		if pathContains(dir, path) && !seen[path] {
+			delete(ingester.sizes, path)
+		}
+	}
+}
+
+// ingestPair is a data file found in an ingest directory, along with its
+// metadata file.  The metadata file may not exist if the source's path
+// pattern or the ingest rules provide the metadata.
+type ingestPair struct {
+	metadataPath      Path
+	dataPath          Path
+	generatedMetadata Properties // from the path pattern and ingest rules, if any
+}
+
+// ingestDirs returns the directories to look for pairs in.
+func ingestDirs(ingestPath Path, recursive bool) []Path {
+	if !recursive {
+		return []Path{ingestPath}
+	}
+
+	dirs := make([]Path, 0)
+
+	filepath.Walk(string(ingestPath), func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+
+		// hidden directories are usually transfers in progress
+		if path != string(ingestPath) && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		dirs = append(dirs, Path(path))
+		return nil
+	})
+
+	return dirs
+}
+
+// findPairs returns the pairs an IngestDirInfo covers.  Scans of new data
+// also return the files that can't be part of a pair: data files without
+// metadata, and sentinels without data.
+func (ingester *DirectoryIngester) findPairs(ingestDirInfo IngestDirInfo) ([]ingestPair, []orphan) {
+	var source *IngestSource
+
+	if found, ok := ingester.sources[ingestDirInfo.IngestPath]; ok && !ingestDirInfo.RemoveOnceIngested {
+		source = &found
+	}
+
+	pathMetadata := func(dataPath Path) (Properties, bool) {
+		if source == nil || source.PathPattern == nil {
+			return nil, false
+		}
+
+		relativePath, err := filepath.Rel(string(ingestDirInfo.IngestPath), string(dataPath))
+
+		if err != nil {
+			return nil, false
+		}
+
+		return source.PathPattern.Match(relativePath)
+	}
+
+	// Data files without a metadata file get whatever the path pattern
+	// captures, under whatever the first matching rule generates.
+	generatedMetadata := func(dataPath Path) (Properties, bool) {
+		captured, pathMatched := pathMetadata(dataPath)
+
+		if source == nil || ingester.settings.Rules == nil {
+			return captured, pathMatched
+		}
+
+		relativePath, err := filepath.Rel(string(ingestDirInfo.IngestPath), string(dataPath))
+
+		if err != nil {
+			return captured, pathMatched
+		}
+
+		generated, ruleMatched := ingester.settings.Rules.Match(relativePath)
+
+		if !ruleMatched {
+			return captured, pathMatched
+		}
+
+		for key, value := range captured {
+			if _, found := generated[key]; !found {
+				generated[key] = value
+			}
+		}
+
+		return generated, true
+	}
+
+	pairs := make([]ingestPair, 0)
+	orphans := make([]orphan, 0)
+
+	if len(ingestDirInfo.MetadataName) > 0 {
+		// The pair may have been picked up by a scan in the meantime.
+		metadataPath := ingestDirInfo.IngestPath.JoinPath(Path(ingestDirInfo.MetadataName))
+		dataPath := metadataPath.WithSuffix("")
+
+		if metadataPath.Exists() {
+			captured, _ := pathMetadata(dataPath)
+			pairs = append(pairs, ingestPair{metadataPath, dataPath, captured})
+		}
+
+		return pairs, orphans
+	}
+
+	recursive := source != nil && source.Recursive
+
+	for _, dir := range ingestDirs(ingestDirInfo.IngestPath, recursive) {
+		metadataPaths, _ := dir.Glob("*" + PropertiesExtension)
 
 		for _, metadataPath := range metadataPaths {
+			dataPath := metadataPath.WithSuffix("") // lop off the extension
+			captured, _ := pathMetadata(dataPath)
+			pairs = append(pairs, ingestPair{metadataPath, dataPath, captured})
+		}
+
+		if source == nil {
+			continue
+		}
+
+		// Data files without a metadata file are ingested too, as long as
+		// the path pattern or the ingest rules can provide their metadata.
+		dataPaths, _ := dir.Glob("*")
+
+		for _, dataPath := range dataPaths {
+			name := dataPath.Name()
+			metadataPath := Path(string(dataPath) + PropertiesExtension)
+
+			if strings.HasSuffix(name, PropertiesExtension) || strings.HasPrefix(name, ".") || !dataPath.IsFile() || metadataPath.Exists() {
+				continue
+			}
+
+			if isSentinel(dataPath) {
+				if !dataPath.WithSuffix("").Exists() {
+					orphans = append(orphans, orphan{[]Path{dataPath}, "Sentinel without a data file", time.Time{}})
+				}
+
+				continue
+			}
+
+			if generated, matched := generatedMetadata(dataPath); matched {
+				pairs = append(pairs, ingestPair{metadataPath, dataPath, generated})
+				continue
+			}
+
+			orphans = append(orphans, orphan{append([]Path{dataPath}, sentinelPaths(dataPath)...), "Data file without a metadata file", time.Time{}})
+		}
+	}
+
+	return pairs, orphans
+}
+
+// ingest queues everything in a directory that's ready for dispatch.
+// Returns false if it stopped early because the queues are full.
+func (ingester *DirectoryIngester) ingest(ingestDirInfo IngestDirInfo) bool {
+	targeted := len(ingestDirInfo.MetadataName) > 0
+
+	// Only new data has to be checked for completeness.  Processor
+	// output is complete by the time it's queued.
+	newData := !ingestDirInfo.RemoveOnceIngested
+	useSentinels := newData && ingester.settings.Completion == IngestCompletionSentinel
+	checkSizes := newData && !useSentinels && !targeted
+	trackOrphans := newData && !targeted
+	seen := make(map[Path]bool)
+	ingestedAny := false
+	pairs, orphans := ingester.findPairs(ingestDirInfo)
+
+	for _, pair := range pairs {
+		metadataPath, dataPath := pair.metadataPath, pair.dataPath
+
+		// without a metadata file, the pair is just the data file
+		pairPaths := []Path{dataPath}
+
+		if metadataPath.Exists() {
 			if !ValidPropertiesFile(metadataPath) {
-				ingester.logger.Printf("Invalid properties file at %s. Skipping...", metadataPath)
+				if trackOrphans {
+					orphans = append(orphans, orphan{[]Path{metadataPath, dataPath}, "Invalid properties file", time.Time{}})
+				} else {
+					ingester.logger.Printf("Invalid properties file at %s. Skipping...", metadataPath)
+				}
+
 				continue
 			}
 
-			dataPath := metadataPath.WithSuffix("") // lop off the extension
+			pairPaths = append(pairPaths, metadataPath)
+		}
 
-			if !dataPath.Exists() {
+		if !dataPath.Exists() {
+			if trackOrphans {
+				orphans = append(orphans, orphan{[]Path{metadataPath}, "Metadata file without a data file", time.Time{}})
+			} else {
 				ingester.logger.Printf("%s does not have corresponding data file", metadataPath)
-				continue
 			}
 
-			now := time.Now()
-			metadataAge, err := metadataPath.Age(now)
+			continue
+		}
+
+		now := time.Now()
+		ready := true
+
+		for _, path := range pairPaths {
+			age, err := path.Age(now)
 
 			if err != nil {
 				ingester.logger.Print(err.Error())
+				ready = false
+				break
+			}
+
+			ready = ready && age > ingestDirInfo.MinAge
+		}
+
+		ready = ready || targeted
+		var sentinels []Path
+
+		if useSentinels {
+			sentinels = sentinelPaths(dataPath)
+			ready = len(sentinels) > 0
+		}
+
+		if checkSizes {
+			for _, path := range pairPaths {
+				seen[path] = true
+			}
+
+			ready = ingester.sizeSettled(pairPaths...) && ready
+		}
+
+		if ready {
+			// Processor output is already inside minnow, so only new
+			// data is held back when the queues are full.
+			if newData && ingester.saturated() {
+				ingester.logger.Printf("Queues are full. Pausing ingest of %s.", ingestDirInfo.IngestPath)
+				return false
+			}
+
+			if newData && metadataPath.Exists() && !ingester.verify(metadataPath, dataPath, sentinels) {
 				continue
 			}
 
-			dataAge, err := dataPath.Age(now)
+			// Move things to a random path in case we're ingesting from the
+			// main ingest directory.  Files that have already been processed
+			// are already in a random directory, but we're moving them anyway
+			// just to be consistent.
+			metadataPath, dataPath, err := moveToRandomPath(ingester.workPath, metadataPath, dataPath, ingestDirInfo.ProcessedBy, ingester.metadataFor(ingestDirInfo, pair))
 
 			if err != nil {
 				ingester.logger.Print(err.Error())
 				continue
 			}
 
-			if metadataAge > ingestDirInfo.MinAge && dataAge > ingestDirInfo.MinAge {
-				// Move things to a random path in case we're ingesting from the
-				// main ingest directory.  Files that have already been processed
-				// are already in a random directory, but we're moving them anyway
-				// just to be consistent.
-				metadataPath, dataPath, err := moveToRandomPath(ingester.workPath, metadataPath, dataPath)
+			dispatchInfo := DispatchInfo{metadataPath, dataPath, ingestDirInfo.ProcessedBy, ""}
+			err = ingester.dispatchQueue.Put(dispatchInfo)
 
-				if err != nil {
-					ingester.logger.Print(err.Error())
-					continue
-				}
+			if err != nil {
+				ingester.logger.Printf("Could not queue %s for dispatch: %s", dataPath, err.Error())
+				continue
+			}
+
+			ingestedAny = true
+			ingester.removeSentinels(sentinels)
+		}
+	}
 
-				dispatchInfo := DispatchInfo{metadataPath, dataPath, ingestDirInfo.ProcessedBy}
-				ingester.dispatchChan <- dispatchInfo
+	if checkSizes {
+		ingester.forgetSizes(ingestDirInfo.IngestPath, seen)
+	}
 
-				if ingestDirInfo.RemoveOnceIngested {
-					err := ingestDirInfo.IngestPath.RmdirRecursive()
+	if trackOrphans {
+		ingester.handleOrphans(ingestDirInfo.IngestPath, ingestDirInfo.MinAge, orphans)
+	}
 
-					if err != nil {
-						ingester.logger.Printf("Could not remove ingest dir: %s", err.Error())
-					}
-				}
+	if source, found := ingester.sources[ingestDirInfo.IngestPath]; found && source.Recursive && trackOrphans {
+		ingester.removeEmptyDirs(ingestDirInfo.IngestPath, ingestDirInfo.MinAge)
+	}
+
+	if ingestDirInfo.RemoveOnceIngested && ingestedAny {
+		err := ingestDirInfo.IngestPath.RmdirRecursive()
+
+		if err != nil {
+			ingester.logger.Printf("Could not remove ingest dir: %s", err.Error())
+		}
+	}
+
+	return true
+}
+
+// removeEmptyDirs removes the directories under a recursive ingest root
+// that have been emptied out, so scans don't keep walking them.  The root
+// stays, and so does anything that changed within minAge, since a file may
+// be about to arrive in it.
+func (ingester *DirectoryIngester) removeEmptyDirs(root Path, minAge time.Duration) {
+	dirs := ingestDirs(root, true)
+	now := time.Now()
+	settled := make(map[Path]bool)
+
+	// check ages first, since removing a directory changes its parent
+	for _, dir := range dirs {
+		age, err := dir.Age(now)
+		settled[dir] = err == nil && age > minAge
+	}
+
+	// parents come before their children, so go backwards to empty out
+	// the children first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+
+		if dir == root || !settled[dir] {
+			continue
+		}
+
+		contents, err := ioutil.ReadDir(string(dir))
+
+		if err != nil || len(contents) > 0 {
+			continue
+		}
+
+		// fails if something arrived in the meantime
+		err = dir.Rmdir()
+
+		if err != nil {
+			debugLogger.Printf("Could not remove empty ingest dir %s: %s", dir, err.Error())
+		}
+	}
+}
+
+// metadataFor returns a function that merges whatever minnow adds to an
+// item's metadata, or nil if the metadata file can be moved as it is.  The
+// item's own metadata wins over anything generated for it, and the
+// source's defaults go under or over both.
+func (ingester *DirectoryIngester) metadataFor(ingestDirInfo IngestDirInfo, pair ingestPair) func(Properties) Properties {
+	if ingestDirInfo.RemoveOnceIngested {
+		return nil
+	}
+
+	source, found := ingester.sources[ingestDirInfo.IngestPath]
+
+	if !found {
+		source = IngestSource{}
+	}
+
+	if len(source.Defaults) == 0 && pair.generatedMetadata == nil && pair.metadataPath.Exists() {
+		return nil
+	}
+
+	return func(metadata Properties) Properties {
+		merged := make(Properties)
+
+		for key, value := range pair.generatedMetadata {
+			merged[key] = value
+		}
+
+		for key, value := range metadata {
+			merged[key] = value
+		}
+
+		return source.ApplyDefaults(merged)
+	}
+}
+
+// verify checks a new pair against the checksum in its metadata, moving it
+// to the quarantine if they don't match.  Returns true if the pair can be
+// ingested.
+func (ingester *DirectoryIngester) verify(metadataPath, dataPath Path, sentinels []Path) bool {
+	reason, err := checksumMismatch(metadataPath, dataPath)
+
+	if err != nil {
+		ingester.logger.Printf("Could not verify %s: %s", dataPath, err.Error())
+		return false
+	}
+
+	if len(reason) == 0 {
+		return true
+	}
+
+	ingester.logger.Printf("Rejecting %s: %s", dataPath, reason)
+	_, err = ingester.quarantine.AddRejected(metadataPath, dataPath, reason)
+
+	if err != nil {
+		ingester.logger.Printf("Could not quarantine %s: %s", dataPath, err.Error())
+		return false
+	}
+
+	ingester.removeSentinels(sentinels)
+	return false
+}
+
+func (ingester *DirectoryIngester) removeSentinels(sentinels []Path) {
+	for _, sentinel := range sentinels {
+		err := sentinel.Unlink()
+
+		if err != nil {
+			ingester.logger.Printf("Could not remove %s: %s", sentinel, err.Error())
+		}
+	}
+}
+
+// handleOrphans keeps track of the files a scan of dir couldn't pair up.
+// Files younger than minAge are left alone, since their other half may
+// still be on its way.  Each orphan is logged once, and moved to the
+// rejected directory if it's still there once the orphan timeout has
+// passed.  Orphans that the scan didn't see again have been dealt with.
+func (ingester *DirectoryIngester) handleOrphans(dir Path, minAge time.Duration, orphans []orphan) {
+	now := time.Now()
+	seen := make(map[Path]bool)
+
+	for _, found := range orphans {
+		key := found.paths[0]
+
+		if age, err := key.Age(now); err != nil || age <= minAge {
+			continue
+		}
+
+		seen[key] = true
+		tracked, ok := ingester.orphans[key]
+
+		if !ok {
+			tracked = &orphan{found.paths, found.reason, now}
+			ingester.orphans[key] = tracked
+
+			if ingester.settings.OrphanTimeout > 0 {
+				ingester.logger.Printf("%s: %s.  Rejecting it in %s unless that changes.", tracked.reason, key, ingester.settings.OrphanTimeout)
+			} else {
+				ingester.logger.Printf("%s: %s", tracked.reason, key)
 			}
+
+			continue
+		}
+
+		tracked.paths = found.paths
+		tracked.reason = found.reason
+
+		if ingester.settings.OrphanTimeout == 0 || now.Sub(tracked.firstSeen) < ingester.settings.OrphanTimeout {
+			continue
+		}
+
+		_, err := ingester.rejectedDir.Add(tracked.paths, tracked.reason, tracked.firstSeen)
+
+		if err != nil {
+			ingester.logger.Printf("Could not reject %s: %s", key, err.Error())
+			continue
+		}
+
+		delete(ingester.orphans, key)
+	}
+
+	for key := range ingester.orphans {
+		if pathContains(dir, key) && !seen[key] {
+			delete(ingester.orphans, key)
 		}
 	}
 }
@@ -0,0 +1,194 @@
+package minnow
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IngestRule generates metadata for data files that don't have a metadata
+// file of their own, based on their names.
+type IngestRule struct {
+	pattern  string
+	regex    *regexp.Regexp
+	fullPath bool              // match the path relative to the ingest directory, rather than the name
+	values   map[string]string // templates that can refer to capture groups
+}
+
+// IngestRules are tried in order, and the first that matches a file
+// provides its metadata.
+type IngestRules struct {
+	rules []IngestRule
+}
+
+func ReadIngestRules(path Path) (*IngestRules, error) {
+	rulesBytes, err := path.ReadBytes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseIngestRules(rulesBytes)
+}
+
+// ParseIngestRules reads rules, one per line, like:
+//
+//	*.dwg -> type = blueprints
+//	/^(\w+)_(\d{8})\.csv$/ -> type = readings, site = $1, date = $2
+//
+// Patterns between slashes are regular expressions, and anything else is a
+// glob, where each wildcard is a capture group.  Blank lines and lines
+// starting with # are skipped.
+func ParseIngestRules(input []byte) (*IngestRules, error) {
+	rules := make([]IngestRule, 0)
+
+	for i, line := range strings.Split(bytes.NewBuffer(input).String(), "\n") {
+		line = strings.TrimSpace(line)
+
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := parseIngestRule(line)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ingest rule on line %d: %s", i+1, err.Error())
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return &IngestRules{rules}, nil
+}
+
+func parseIngestRule(line string) (IngestRule, error) {
+	parts := strings.SplitN(line, " -> ", 2)
+
+	if len(parts) != 2 {
+		return IngestRule{}, fmt.Errorf("must look like <pattern> -> <key> = <value>, ...")
+	}
+
+	pattern := strings.TrimSpace(parts[0])
+	var regexStr string
+
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		regexStr = pattern[1 : len(pattern)-1]
+	} else {
+		var err error
+		regexStr, err = globToRegex(pattern)
+
+		if err != nil {
+			return IngestRule{}, err
+		}
+	}
+
+	regex, err := regexp.Compile(regexStr)
+
+	if err != nil {
+		return IngestRule{}, fmt.Errorf("invalid regular expression %s: %s", pattern, err.Error())
+	}
+
+	values := make(map[string]string)
+
+	for _, assignment := range strings.Split(parts[1], ",") {
+		keyValue := strings.SplitN(assignment, "=", 2)
+
+		if len(keyValue) != 2 {
+			return IngestRule{}, fmt.Errorf("%s must look like <key> = <value>", strings.TrimSpace(assignment))
+		}
+
+		key := strings.TrimSpace(keyValue[0])
+
+		if len(key) == 0 || strings.ContainsAny(key, " \t") {
+			return IngestRule{}, fmt.Errorf("invalid key %s", key)
+		}
+
+		values[key] = strings.TrimSpace(keyValue[1])
+	}
+
+	// like a path pattern, a rule with directories in it has to match the
+	// whole path
+	fullPath := strings.Contains(strings.Trim(pattern, "/"), "/")
+	return IngestRule{pattern, regex, fullPath, values}, nil
+}
+
+// globToRegex translates a glob to an anchored regular expression, with a
+// capture group for each wildcard or character class.
+func globToRegex(glob string) (string, error) {
+	var buffer strings.Builder
+	buffer.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			buffer.WriteString("([^/]*)")
+		case '?':
+			buffer.WriteString("([^/])")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+
+			if end < 0 {
+				return "", fmt.Errorf("unclosed [ in %s", glob)
+			}
+
+			class := glob[i+1 : i+1+end]
+
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			buffer.WriteString("([" + class + "])")
+			i += end + 1
+		default:
+			buffer.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+
+	buffer.WriteString("$")
+	return buffer.String(), nil
+}
+
+// Match returns the metadata generated by the first rule that matches
+// relativePath, the path of a data file relative to the directory it was
+// dropped off in.  Returns false if no rule matches.
+func (rules *IngestRules) Match(relativePath string) (Properties, bool) {
+	relativePath = filepath.ToSlash(relativePath)
+	name := relativePath[strings.LastIndex(relativePath, "/")+1:]
+
+	for _, rule := range rules.rules {
+		subject := name
+
+		if rule.fullPath {
+			subject = relativePath
+		}
+
+		match := rule.regex.FindStringSubmatchIndex(subject)
+
+		if match == nil {
+			continue
+		}
+
+		metadata := make(Properties)
+		valid := true
+
+		for key, template := range rule.values {
+			value := string(rule.regex.ExpandString(nil, template, subject, match))
+
+			// the value has to survive being written as a property
+			if strings.ContainsAny(value, "=\n") {
+				valid = false
+				break
+			}
+
+			metadata[key] = strings.TrimSpace(value)
+		}
+
+		if valid {
+			return metadata, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,117 @@
+package minnow
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIngestRules(t *testing.T) {
+	rulesStr := `
+		# blueprints
+		*.dwg -> type = blueprints
+
+		/^(?P<site>\w+)_(\d{8})\.csv$/ -> type = readings, site = ${site}, date = $2
+		archive/*/*.tar -> type = archive, year = $1, name = $2
+		report-?.[!x]* -> type = report, number = $1`
+	rules, err := ParseIngestRules([]byte(rulesStr))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tests := []struct {
+		relativePath string
+		expected     Properties
+	}{
+		{"plans.dwg", Properties{"type": "blueprints"}},
+		{"site/2026/plans.dwg", Properties{"type": "blueprints"}},
+		{"north_20261018.csv", Properties{"type": "readings", "site": "north", "date": "20261018"}},
+		{"archive/2025/old.tar", Properties{"type": "archive", "year": "2025", "name": "old"}},
+		{"report-7.pdf", Properties{"type": "report", "number": "7"}},
+	}
+
+	for _, test := range tests {
+		metadata, matched := rules.Match(test.relativePath)
+
+		if !matched {
+			t.Errorf("Expected %s to match", test.relativePath)
+			continue
+		}
+
+		if len(metadata) != len(test.expected) {
+			t.Errorf("Incorrect metadata for %s: %v", test.relativePath, metadata)
+		}
+
+		for key, value := range test.expected {
+			if metadata[key] != value {
+				t.Errorf("Incorrect metadata for %s: %v", test.relativePath, metadata)
+			}
+		}
+	}
+
+	for _, relativePath := range []string{"plans.dwg.bak", "north_2026.csv", "other/2025/old.tar", "report-7.xls"} {
+		if _, matched := rules.Match(relativePath); matched {
+			t.Errorf("Expected %s not to match", relativePath)
+		}
+	}
+}
+
+func TestParseIngestRulesErrors(t *testing.T) {
+	for _, rulesStr := range []string{
+		"*.dwg type = blueprints",
+		"/(unclosed/ -> type = broken",
+		"[abc -> type = broken",
+		"*.dwg -> blueprints",
+		"*.dwg -> = blueprints",
+	} {
+		_, err := ParseIngestRules([]byte("# comment\n" + rulesStr))
+
+		if err == nil {
+			t.Errorf("Expected an error for %s", rulesStr)
+		} else if !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("Expected the error for %s to give its line: %s", rulesStr, err.Error())
+		}
+	}
+}
+
+func TestIngestRulesWithoutSidecar(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	source := IngestSource{Name: DefaultIngestSourceName, Path: ingestPath, Defaults: Properties{"source": "legacy"}}
+	ingester := makeTestIngester(t, workPath, IngestCompletionAge, source)
+	defer ingester.processorRegistry.Shutdown(0)
+	ingester.settings.Rules, _ = ParseIngestRules([]byte("*.dwg -> type = blueprints, name = $1"))
+
+	ingestPath.JoinPath("plans.dwg").WriteBytes([]byte("plans"))
+	ingestPath.JoinPath("notes.txt").WriteBytes([]byte("notes"))
+	scan := IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, ""}
+	ingester.ingest(scan)
+	ingester.ingest(scan)
+
+	entries := ingester.dispatchQueue.Entries()
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the file matching a rule to be ingested, got %d", len(entries))
+	}
+
+	dispatchInfo, _ := DispatchInfoFromProperties(entries[0])
+	metadata, err := PropertiesFromFile(dispatchInfo.MetadataPath)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if metadata["type"] != "blueprints" || metadata["name"] != "plans" || metadata["source"] != "legacy" {
+		t.Errorf("Incorrect metadata generated: %v", metadata)
+	}
+
+	if !ingestPath.JoinPath("notes.txt").Exists() {
+		t.Errorf("File without metadata or a matching rule should be left alone")
+	}
+}
@@ -0,0 +1,225 @@
+package minnow
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultIngestSourceName = "default"
+
+	DefaultsUnder = "under"
+	DefaultsOver  = "over"
+)
+
+// IngestSource is a directory that new data is dropped off in.  Each
+// source can have its own minimum age, and default metadata that is merged
+// with the metadata of everything ingested from it.
+type IngestSource struct {
+	Name         string
+	Path         Path
+	MinAge       time.Duration
+	Defaults     Properties
+	DefaultsOver bool // defaults replace keys the metadata already has
+	Recursive    bool // ingest from subdirectories too
+	PathPattern  *PathPattern
+}
+
+// ApplyDefaults returns metadata merged with the source's defaults.
+func (source IngestSource) ApplyDefaults(metadata Properties) Properties {
+	merged := make(Properties)
+
+	for key, value := range metadata {
+		merged[key] = value
+	}
+
+	for key, value := range source.Defaults {
+		if _, found := merged[key]; !found || source.DefaultsOver {
+			merged[key] = value
+		}
+	}
+
+	return merged
+}
+
+// parseIngestSource reads the settings of the source with the given name,
+// which are all prefixed with ingest_source.<name>.
+func parseIngestSource(properties Properties, name string, defaultMinAge time.Duration) (IngestSource, error) {
+	prefix := "ingest_source." + name + "."
+	source := IngestSource{Name: name, Defaults: make(Properties)}
+
+	//
+	// dir
+	//
+	pathStr, found := properties[prefix+"dir"]
+
+	if !found {
+		return IngestSource{}, fmt.Errorf("%sdir missing from config file", prefix)
+	}
+
+	source.Path = Path(pathStr)
+
+	if !source.Path.Exists() {
+		return IngestSource{}, fmt.Errorf("%sdir does not exist at %s", prefix, source.Path)
+	}
+
+	//
+	// min_age
+	//
+	minAge, err := parseSeconds(properties, prefix+"min_age", defaultMinAge)
+
+	if err != nil {
+		return IngestSource{}, err
+	}
+
+	source.MinAge = minAge
+
+	//
+	// defaults
+	//
+	defaultsPathStr, found := properties[prefix+"defaults"]
+
+	if found {
+		source.Defaults, err = PropertiesFromFile(Path(defaultsPathStr))
+
+		if err != nil {
+			return IngestSource{}, fmt.Errorf("Could not read %sdefaults: %s", prefix, err.Error())
+		}
+	}
+
+	//
+	// defaults_merge
+	//
+	merge, found := properties[prefix+"defaults_merge"]
+
+	if !found {
+		merge = DefaultsUnder
+	}
+
+	if merge != DefaultsUnder && merge != DefaultsOver {
+		return IngestSource{}, fmt.Errorf("%sdefaults_merge must be %s or %s", prefix, DefaultsUnder, DefaultsOver)
+	}
+
+	source.DefaultsOver = merge == DefaultsOver
+
+	//
+	// recursive and path_pattern
+	//
+	source.Recursive, source.PathPattern, err = parseIngestLayout(properties, prefix+"recursive", prefix+"path_pattern")
+
+	if err != nil {
+		return IngestSource{}, err
+	}
+
+	return source, nil
+}
+
+// parseIngestLayout reads whether a source is ingested recursively, and
+// the pattern its paths are mapped to metadata with, if any.
+func parseIngestLayout(properties Properties, recursiveKey, patternKey string) (bool, *PathPattern, error) {
+	recursive := false
+	recursiveStr, found := properties[recursiveKey]
+
+	if found {
+		var err error
+		recursive, err = strconv.ParseBool(recursiveStr)
+
+		if err != nil {
+			return false, nil, fmt.Errorf("%s must be true or false", recursiveKey)
+		}
+	}
+
+	patternStr, found := properties[patternKey]
+
+	if !found {
+		return recursive, nil, nil
+	}
+
+	pattern, err := ParsePathPattern(patternStr)
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	if pattern.Depth() > 0 && !recursive {
+		return false, nil, fmt.Errorf("%s has directories in it, so %s must be true", patternKey, recursiveKey)
+	}
+
+	return recursive, pattern, nil
+}
+
+// parseIngestSources reads the sources named in ingest_sources.
+func parseIngestSources(properties Properties, defaultMinAge time.Duration) ([]IngestSource, error) {
+	sources := make([]IngestSource, 0)
+
+	for _, name := range strings.Split(properties["ingest_sources"], ",") {
+		name = strings.TrimSpace(name)
+
+		if len(name) == 0 {
+			continue
+		}
+
+		if strings.ContainsAny(name, ". \t") || name == DefaultIngestSourceName {
+			return nil, fmt.Errorf("Invalid ingest source name %s", name)
+		}
+
+		source, err := parseIngestSource(properties, name, defaultMinAge)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// checkIngestSourcesDistinct makes sure no two sources share a name or a
+// directory, and that recursive sources don't contain other sources.
+func checkIngestSourcesDistinct(sources []IngestSource) error {
+	names := make(map[string]bool)
+	paths := make([]Path, 0, len(sources))
+
+	for _, source := range sources {
+		if names[source.Name] {
+			return fmt.Errorf("Ingest source %s is listed more than once", source.Name)
+		}
+
+		names[source.Name] = true
+		path, err := source.Path.Resolve()
+
+		if err != nil {
+			path = source.Path
+		}
+
+		paths = append(paths, path)
+	}
+
+	for i, source := range sources {
+		for j, other := range sources {
+			if i == j {
+				continue
+			}
+
+			if paths[i] == paths[j] {
+				return fmt.Errorf("Ingest sources %s and %s share the directory %s", source.Name, other.Name, source.Path)
+			}
+
+			if source.Recursive && pathContains(paths[i], paths[j]) {
+				return fmt.Errorf("Ingest source %s is recursive, so it can't contain ingest source %s", source.Name, other.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// pathContains returns true if path is somewhere under dir.
+func pathContains(dir, path Path) bool {
+	relativePath, err := filepath.Rel(string(dir), string(path))
+	return err == nil && relativePath != "." && !strings.HasPrefix(relativePath, "..")
+}
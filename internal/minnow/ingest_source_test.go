@@ -0,0 +1,101 @@
+package minnow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyDefaults(t *testing.T) {
+	metadata := Properties{"type": "text", "name": "item"}
+	source := IngestSource{"teamA", "/tmp", time.Duration(0), Properties{"type": "binary", "source": "teamA"}, false, false, nil}
+	merged := source.ApplyDefaults(metadata)
+
+	if merged["type"] != "text" || merged["source"] != "teamA" || merged["name"] != "item" {
+		t.Errorf("Defaults merged under metadata gave %v", merged)
+	}
+
+	source.DefaultsOver = true
+	merged = source.ApplyDefaults(metadata)
+
+	if merged["type"] != "binary" || merged["source"] != "teamA" || merged["name"] != "item" {
+		t.Errorf("Defaults merged over metadata gave %v", merged)
+	}
+
+	if metadata["type"] != "text" || len(metadata) != 2 {
+		t.Errorf("Metadata should not be changed by merging")
+	}
+}
+
+func TestParseIngestSources(t *testing.T) {
+	defaultsPath := Path("/tmp/minnow-defaults-" + randomString(20))
+	defaultsPath.WriteBytes([]byte("source = teamA"))
+	defer defaultsPath.Unlink()
+
+	configProperties := Properties{
+		"ingest_dir":                         "/tmp",
+		"ingest_min_age":                     "600",
+		"ingest_sources":                     "teamA, teamB",
+		"ingest_source.teamA.dir":            "/var",
+		"ingest_source.teamA.min_age":        "30",
+		"ingest_source.teamA.defaults":       string(defaultsPath),
+		"ingest_source.teamA.defaults_merge": "over",
+		"ingest_source.teamB.dir":            "/usr",
+		"work_dir":                           "/var",
+		"processor_definitions_dir":          "/usr",
+	}
+
+	config, err := ParseConfig(configProperties)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(config.IngestSources) != 3 {
+		t.Fatalf("Expected 3 ingest sources, got %d", len(config.IngestSources))
+	}
+
+	teamA := config.IngestSources[1]
+
+	if teamA.Name != "teamA" || teamA.Path != "/var" || teamA.MinAge != time.Duration(30)*time.Second || !teamA.DefaultsOver || teamA.Defaults["source"] != "teamA" {
+		t.Errorf("Incorrect ingest source %v", teamA)
+	}
+
+	teamB := config.IngestSources[2]
+
+	if teamB.MinAge != time.Duration(600)*time.Second || teamB.DefaultsOver || len(teamB.Defaults) != 0 {
+		t.Errorf("Expected teamB to get the default settings, got %v", teamB)
+	}
+
+	configProperties["ingest_source.teamB.path_pattern"] = "{site}/*"
+	_, err = ParseConfig(configProperties)
+
+	if err == nil {
+		t.Errorf("Expected an error for a path pattern with directories on a source that isn't recursive")
+	}
+
+	configProperties["ingest_source.teamB.recursive"] = "true"
+	config, err = ParseConfig(configProperties)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !config.IngestSources[2].Recursive || config.IngestSources[2].PathPattern == nil {
+		t.Errorf("Expected teamB to be recursive with a path pattern")
+	}
+
+	configProperties["ingest_source.teamB.dir"] = "/tmp"
+	_, err = ParseConfig(configProperties)
+
+	if err == nil {
+		t.Errorf("Expected an error for sources sharing a directory")
+	}
+
+	delete(configProperties, "ingest_dir")
+	delete(configProperties, "ingest_source.teamA.dir")
+	_, err = ParseConfig(configProperties)
+
+	if err == nil {
+		t.Errorf("Expected an error for a source without a directory")
+	}
+}
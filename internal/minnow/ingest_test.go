@@ -0,0 +1,472 @@
+package minnow
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeTestIngester(t *testing.T, workPath Path, completion string, sources ...IngestSource) *DirectoryIngester {
+	spoolPath := workPath.JoinPath("spool")
+	ingestDirQueue, err := NewIngestDirQueue(spoolPath.JoinPath("ingest"), 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	dispatchQueue, err := NewDispatchQueue(spoolPath.JoinPath("dispatch"), 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	definitionsPath := workPath.JoinPath("processors")
+	definitionsPath.Mkdir()
+	makeTestProcessorDefinition(t, "pool_size = 1", "true").Rename(definitionsPath.JoinPath("test"))
+	registry := makeTestRegistry(t, definitionsPath, workPath)
+	quarantine, err := NewQuarantine(workPath.JoinPath("failed"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	rejectedDir, err := NewRejectedDir(workPath.JoinPath("rejected"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return NewDirectoryIngester(workPath, ingestDirQueue, dispatchQueue, registry, quarantine, rejectedDir, IngestSettings{sources, nil, completion, 0})
+}
+
+func makeTestPair(t *testing.T, ingestPath Path, name string) {
+	err := ingestPath.JoinPath(Path(name)).WriteBytes([]byte("data"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	err = ingestPath.JoinPath(Path(name + PropertiesExtension)).WriteBytes([]byte("type = test"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestIngestWaitsForSizeToSettle(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	ingester := makeTestIngester(t, workPath, IngestCompletionAge)
+	defer ingester.processorRegistry.Shutdown(0)
+	makeTestPair(t, ingestPath, "growing.txt")
+	scan := IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, ""}
+
+	// the first scan only records sizes
+	ingester.ingest(scan)
+
+	if ingester.dispatchQueue.Len() != 0 {
+		t.Fatalf("Pair should not be ingested before its size has been seen twice")
+	}
+
+	ingestPath.JoinPath("growing.txt").WriteBytes([]byte("more data"))
+	ingester.ingest(scan)
+
+	if ingester.dispatchQueue.Len() != 0 {
+		t.Fatalf("Pair should not be ingested while its size is changing")
+	}
+
+	ingester.ingest(scan)
+
+	if ingester.dispatchQueue.Len() != 1 {
+		t.Fatalf("Pair should be ingested once its size has settled")
+	}
+
+	if len(ingester.sizes) != 2 {
+		t.Errorf("Expected sizes of the ingested pair to be kept until the next scan, found %d", len(ingester.sizes))
+	}
+
+	ingester.ingest(scan)
+
+	if len(ingester.sizes) != 0 {
+		t.Errorf("Sizes of files that are gone should be forgotten")
+	}
+}
+
+func TestIngestSinglePair(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	ingester := makeTestIngester(t, workPath, IngestCompletionAge)
+	defer ingester.processorRegistry.Shutdown(0)
+	makeTestPair(t, ingestPath, "ready.txt")
+	makeTestPair(t, ingestPath, "other.txt")
+
+	// a targeted ingest doesn't wait for the pair to age
+	ingester.ingest(IngestDirInfo{ingestPath, time.Duration(1) * time.Hour, make([]ProcessorId, 0), false, "ready.txt" + PropertiesExtension})
+
+	if ingester.dispatchQueue.Len() != 1 {
+		t.Fatalf("Expected only the targeted pair to be ingested")
+	}
+
+	if ingestPath.JoinPath("ready.txt").Exists() || !ingestPath.JoinPath("other.txt").Exists() {
+		t.Errorf("Expected only the targeted pair to be moved")
+	}
+
+	// the pair is gone if a scan got to it first
+	ingester.ingest(IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, "ready.txt" + PropertiesExtension})
+
+	if ingester.dispatchQueue.Len() != 1 {
+		t.Errorf("Expected nothing more to be ingested")
+	}
+}
+
+func TestIngestSentinel(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	ingester := makeTestIngester(t, workPath, IngestCompletionSentinel)
+	defer ingester.processorRegistry.Shutdown(0)
+	makeTestPair(t, ingestPath, "marked.txt")
+	scan := IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, ""}
+	ingester.ingest(scan)
+
+	if ingester.dispatchQueue.Len() != 0 {
+		t.Fatalf("Pair should not be ingested before its sentinel exists")
+	}
+
+	sentinelPath := ingestPath.JoinPath("marked.txt.done")
+	sentinelPath.WriteBytes([]byte{})
+	ingester.ingest(scan)
+
+	if ingester.dispatchQueue.Len() != 1 {
+		t.Fatalf("Pair should be ingested once its sentinel exists")
+	}
+
+	if sentinelPath.Exists() {
+		t.Errorf("Sentinel should be removed once the pair is ingested")
+	}
+}
+
+func TestIngestChecksum(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	ingester := makeTestIngester(t, workPath, IngestCompletionAge)
+	defer ingester.processorRegistry.Shutdown(0)
+
+	// sha256 of "data"
+	checksum := "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"
+	makeTestPair(t, ingestPath, "good.txt")
+	ingestPath.JoinPath("good.txt" + PropertiesExtension).WriteBytes([]byte(ChecksumKey + " = " + strings.ToUpper(checksum)))
+	makeTestPair(t, ingestPath, "bad.txt")
+	ingestPath.JoinPath("bad.txt" + PropertiesExtension).WriteBytes([]byte(ChecksumKey + " = " + strings.Repeat("0", 64)))
+
+	for _, name := range []string{"good.txt", "bad.txt"} {
+		ingester.ingest(IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, name + PropertiesExtension})
+	}
+
+	if ingester.dispatchQueue.Len() != 1 {
+		t.Fatalf("Expected only the pair with a matching checksum to be ingested")
+	}
+
+	failedPaths, _ := ingester.quarantine.Path().Glob(rejectedIngestName + "-*")
+
+	if len(failedPaths) != 1 {
+		t.Fatalf("Expected the pair with a bad checksum to be quarantined")
+	}
+
+	if !failedPaths[0].JoinPath("input", "bad.txt").Exists() || !failedPaths[0].JoinPath(Path(FailureReportName)).Exists() {
+		t.Errorf("Expected the rejected pair and a failure report in %s", failedPaths[0])
+	}
+}
+
+func TestIngestSourceDefaults(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	source := IngestSource{"teamA", ingestPath, time.Duration(0), Properties{"source": "teamA", "type": "default"}, false, false, nil}
+	ingester := makeTestIngester(t, workPath, IngestCompletionAge, source)
+	defer ingester.processorRegistry.Shutdown(0)
+	makeTestPair(t, ingestPath, "item.txt")
+	ingester.ingest(IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, "item.txt" + PropertiesExtension})
+
+	entries := ingester.dispatchQueue.Entries()
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected the pair to be ingested")
+	}
+
+	dispatchInfo, _ := DispatchInfoFromProperties(entries[0])
+	metadata, err := PropertiesFromFile(dispatchInfo.MetadataPath)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if metadata["source"] != "teamA" || metadata["type"] != "test" {
+		t.Errorf("Expected defaults to be merged under the metadata, got %v", metadata)
+	}
+
+	if ingestPath.JoinPath("item.txt" + PropertiesExtension).Exists() {
+		t.Errorf("Original metadata should be removed once the pair is ingested")
+	}
+}
+
+func TestIngestRecursivePathPattern(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	pattern, err := ParsePathPattern("{site}/{year}/*")
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	source := IngestSource{"sites", ingestPath, time.Duration(0), make(Properties), false, true, pattern}
+	ingester := makeTestIngester(t, workPath, IngestCompletionAge, source)
+	defer ingester.processorRegistry.Shutdown(0)
+
+	for _, dir := range []Path{"north", "north/2026", "south", "south/2025", ".partial"} {
+		ingestPath.JoinPath(dir).Mkdir()
+	}
+
+	// metadata comes from the path alone
+	ingestPath.JoinPath("north", "2026", "a.csv").WriteBytes([]byte("a"))
+	// the item's own metadata wins over its path
+	makeTestPair(t, ingestPath.JoinPath("south", "2025"), "b.csv")
+	ingestPath.JoinPath("south", "2025", "b.csv"+PropertiesExtension).WriteBytes([]byte("site = elsewhere"))
+	// neither of these fit the pattern, or have metadata
+	ingestPath.JoinPath("c.csv").WriteBytes([]byte("c"))
+	ingestPath.JoinPath(".partial", "d.csv").WriteBytes([]byte("d"))
+
+	scan := IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, ""}
+	ingester.ingest(scan)
+	ingester.ingest(scan)
+
+	entries := ingester.dispatchQueue.Entries()
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 items to be ingested, got %d", len(entries))
+	}
+
+	metadataByName := make(map[string]Properties)
+
+	for _, entry := range entries {
+		dispatchInfo, _ := DispatchInfoFromProperties(entry)
+		metadata, err := PropertiesFromFile(dispatchInfo.MetadataPath)
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		metadataByName[dispatchInfo.DataPath.Name()] = metadata
+	}
+
+	if metadata := metadataByName["a.csv"]; metadata["site"] != "north" || metadata["year"] != "2026" {
+		t.Errorf("Incorrect metadata for a.csv: %v", metadata)
+	}
+
+	if metadata := metadataByName["b.csv"]; metadata["site"] != "elsewhere" || metadata["year"] != "2025" {
+		t.Errorf("Incorrect metadata for b.csv: %v", metadata)
+	}
+
+	if ingestPath.JoinPath("south", "2025", "b.csv"+PropertiesExtension).Exists() {
+		t.Errorf("Original metadata should be removed once the pair is ingested")
+	}
+}
+
+func TestIngestOrphans(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	source := IngestSource{Name: DefaultIngestSourceName, Path: ingestPath}
+	ingester := makeTestIngester(t, workPath, IngestCompletionAge, source)
+	defer ingester.processorRegistry.Shutdown(0)
+	ingester.settings.OrphanTimeout = time.Duration(100) * time.Millisecond
+
+	ingestPath.JoinPath("invalid.txt").WriteBytes([]byte("data"))
+	ingestPath.JoinPath("invalid.txt" + PropertiesExtension).WriteBytes([]byte("not a property"))
+	ingestPath.JoinPath("lonely.txt" + PropertiesExtension).WriteBytes([]byte("type = test"))
+	ingestPath.JoinPath("bare.txt").WriteBytes([]byte("data"))
+	ingestPath.JoinPath("lost.txt.ready").WriteBytes([]byte{})
+	ingestPath.JoinPath("late.txt").WriteBytes([]byte("data"))
+	scan := IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, ""}
+	ingester.ingest(scan)
+
+	if len(ingester.orphans) != 5 {
+		t.Fatalf("Expected 5 orphans to be tracked, got %d", len(ingester.orphans))
+	}
+
+	// an orphan whose other half shows up is forgotten
+	ingestPath.JoinPath("late.txt" + PropertiesExtension).WriteBytes([]byte("type = test"))
+	time.Sleep(time.Duration(200) * time.Millisecond)
+	ingester.ingest(scan)
+
+	if _, found := ingester.orphans[ingestPath.JoinPath("late.txt")]; found {
+		t.Errorf("Orphan should be forgotten once its metadata shows up")
+	}
+
+	rejectedPaths, _ := ingester.rejectedDir.Path().Glob("*")
+
+	if len(rejectedPaths) != 4 {
+		t.Fatalf("Expected 4 rejections, got %d", len(rejectedPaths))
+	}
+
+	for _, name := range []string{"invalid.txt", "invalid.txt" + PropertiesExtension, "lonely.txt" + PropertiesExtension, "bare.txt", "lost.txt.ready"} {
+		if ingestPath.JoinPath(Path(name)).Exists() {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+
+	invalidPaths, _ := ingester.rejectedDir.Path().Glob("invalid.txt" + PropertiesExtension + "-*")
+
+	if len(invalidPaths) != 1 {
+		t.Fatalf("Expected a rejection for invalid.txt")
+	}
+
+	report, err := PropertiesFromFile(invalidPaths[0].JoinPath(Path(RejectionReportName)))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if report["reason"] != "Invalid properties file" || !invalidPaths[0].JoinPath("invalid.txt").Exists() {
+		t.Errorf("Expected the invalid pair to be rejected together with a reason, got %v", report)
+	}
+
+	if len(ingester.orphans) != 0 {
+		t.Errorf("Rejected orphans should be forgotten")
+	}
+}
+
+func TestIngestPausesNewDataWhenSaturated(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	outputPath := Path("/tmp/minnow-output-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	outputPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer outputPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	ingester := makeTestIngester(t, workPath, IngestCompletionAge)
+	defer ingester.processorRegistry.Shutdown(0)
+
+	// nothing takes from the parked queue while the circuit is closed,
+	// so filling it keeps the registry saturated
+	var processorId ProcessorId
+
+	for id, parkedQueue := range ingester.processorRegistry.parkedQueues {
+		processorId = id
+		parkedQueue.capacity = 1
+		parkedQueue.Spool.Put(Properties{"input_dir": "/nowhere", "output_dir": "/nowhere"})
+	}
+
+	if !ingester.processorRegistry.Saturated() {
+		t.Fatalf("Registry should be saturated")
+	}
+
+	makeTestPair(t, ingestPath, "new.txt")
+	scan := IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, ""}
+
+	// the first scan only records sizes, so the second is the one that
+	// finds the queues full
+	ingester.ingest(scan)
+
+	if ingester.ingest(scan) {
+		t.Errorf("Ingest of new data should pause while saturated")
+	}
+
+	if ingester.dispatchQueue.Len() != 0 || !ingestPath.JoinPath("new.txt").Exists() {
+		t.Errorf("New data should be left where it is while saturated")
+	}
+
+	makeTestPair(t, outputPath, "output.txt")
+	output := IngestDirInfo{outputPath, time.Duration(0), []ProcessorId{processorId}, true, ""}
+
+	if !ingester.ingest(output) {
+		t.Errorf("Ingest of processor output should not pause while saturated")
+	}
+
+	if ingester.dispatchQueue.Len() != 1 || outputPath.Exists() {
+		t.Errorf("Processor output should be ingested while saturated")
+	}
+}
+
+func TestIngestRemovesEmptyDirs(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	ingestPath.Mkdir()
+	workPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	source := IngestSource{"sites", ingestPath, time.Duration(0), make(Properties), false, true, nil}
+	ingester := makeTestIngester(t, workPath, IngestCompletionAge, source)
+	defer ingester.processorRegistry.Shutdown(0)
+
+	for _, dir := range []Path{"north", "north/2026", "uploading", ".partial"} {
+		ingestPath.JoinPath(dir).Mkdir()
+	}
+
+	makeTestPair(t, ingestPath.JoinPath("north", "2026"), "a.csv")
+	ingestPath.JoinPath("uploading", ".b.csv.part").WriteBytes([]byte("b"))
+
+	// the first scan empties north/2026, and the second removes it,
+	// along with north, which only had it in it
+	scan := IngestDirInfo{ingestPath, time.Duration(0), make([]ProcessorId, 0), false, ""}
+	ingester.ingest(scan)
+	ingester.ingest(scan)
+
+	if len(ingester.dispatchQueue.Entries()) != 1 {
+		t.Fatalf("Expected the pair to be ingested")
+	}
+
+	if ingestPath.JoinPath("north").Exists() {
+		t.Errorf("Empty directories should have been removed")
+	}
+
+	for _, dir := range []Path{"", "uploading", ".partial"} {
+		if !ingestPath.JoinPath(dir).IsDir() {
+			t.Errorf("%s should not have been removed", ingestPath.JoinPath(dir))
+		}
+	}
+
+	// a directory that was just made may be about to get a file
+	ingestPath.JoinPath("fresh").Mkdir()
+	ingester.ingest(IngestDirInfo{ingestPath, time.Duration(1) * time.Hour, make([]ProcessorId, 0), false, ""})
+
+	if !ingestPath.JoinPath("fresh").IsDir() {
+		t.Errorf("Directory newer than the minimum age should not have been removed")
+	}
+}
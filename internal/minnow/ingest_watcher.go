@@ -0,0 +1,156 @@
+package minnow
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// how often to forget about files that went away before their other
+	// half showed up
+	ingestWatchPruneInterval = time.Duration(1) * time.Minute
+)
+
+// IngestWatcher queues each data and metadata pair in the ingest
+// directory as soon as both files have been written, rather than waiting
+// for the next scan to find them.  Scans still run, to catch anything the
+// watcher misses.
+type IngestWatcher struct {
+	ingestPath     Path
+	minAge         time.Duration
+	completion     string
+	ingestDirQueue *IngestDirQueue
+	complete       map[Path]bool // files that are written, waiting on their other half
+	events         <-chan WatchEvent
+	stop           func() error
+	logger         *log.Logger
+}
+
+// NewIngestWatcher starts watching the source's directory, and everything
+// under it if the source is recursive.  Returns an error if watching isn't
+// supported, in which case ingest relies on scans alone.
+func NewIngestWatcher(source IngestSource, completion string, ingestDirQueue *IngestDirQueue) (*IngestWatcher, error) {
+	events, stop, err := watchDir(source.Path, source.Recursive)
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.New(os.Stdout, "IngestWatcher: ", 0)
+	return &IngestWatcher{source.Path, source.MinAge, completion, ingestDirQueue, make(map[Path]bool), events, stop, logger}, nil
+}
+
+// pairFor returns the metadata and data paths of the pair that path
+// belongs to.
+func pairFor(path Path) (Path, Path) {
+	if strings.HasSuffix(string(path), PropertiesExtension) {
+		return path, path.WithSuffix("")
+	}
+
+	return Path(string(path) + PropertiesExtension), path
+}
+
+func (watcher *IngestWatcher) Run() {
+	ticker := time.NewTicker(ingestWatchPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.events:
+			if !ok {
+				return
+			}
+
+			watcher.handle(event)
+		case <-ticker.C:
+			watcher.prune()
+		}
+	}
+}
+
+func (watcher *IngestWatcher) handle(event WatchEvent) {
+	if event.Overflow {
+		watcher.logger.Printf("Missed changes to %s, scanning it", watcher.ingestPath)
+		watcher.queue(IngestDirInfo{watcher.ingestPath, watcher.minAge, make([]ProcessorId, 0), false, ""})
+		return
+	}
+
+	if !event.Complete {
+		return
+	}
+
+	// with sentinels, only the marker file says the pair is complete
+	if watcher.completion == IngestCompletionSentinel {
+		if !isSentinel(event.Path) {
+			return
+		}
+
+		dataPath := event.Path.WithSuffix("")
+		metadataPath := Path(string(dataPath) + PropertiesExtension)
+
+		if metadataPath.Exists() && dataPath.Exists() {
+			watcher.queuePair(metadataPath)
+		}
+
+		return
+	}
+
+	watcher.complete[event.Path] = true
+	metadataPath, dataPath := pairFor(event.Path)
+
+	if !watcher.written(metadataPath) || !watcher.written(dataPath) {
+		return
+	}
+
+	delete(watcher.complete, metadataPath)
+	delete(watcher.complete, dataPath)
+	watcher.queuePair(metadataPath)
+}
+
+// queuePair queues the ingest of a single pair, which may be in a
+// subdirectory of a recursive source.
+func (watcher *IngestWatcher) queuePair(metadataPath Path) {
+	metadataName, err := filepath.Rel(string(watcher.ingestPath), string(metadataPath))
+
+	if err != nil {
+		watcher.logger.Print(err.Error())
+		return
+	}
+
+	watcher.queue(IngestDirInfo{watcher.ingestPath, watcher.minAge, make([]ProcessorId, 0), false, metadataName})
+}
+
+// written returns true if the file at path has finished being written.
+// Files that were there before the watcher started count once they're
+// older than the minimum age.
+func (watcher *IngestWatcher) written(path Path) bool {
+	if watcher.complete[path] {
+		return true
+	}
+
+	age, err := path.Age(time.Now())
+	return err == nil && age > watcher.minAge
+}
+
+func (watcher *IngestWatcher) queue(ingestDirInfo IngestDirInfo) {
+	err := watcher.ingestDirQueue.Put(ingestDirInfo)
+
+	if err != nil {
+		watcher.logger.Printf("Could not queue ingest of %s: %s", watcher.ingestPath, err.Error())
+	}
+}
+
+func (watcher *IngestWatcher) prune() {
+	for path := range watcher.complete {
+		if !path.Exists() {
+			delete(watcher.complete, path)
+		}
+	}
+}
+
+func (watcher *IngestWatcher) Close() error {
+	return watcher.stop()
+}
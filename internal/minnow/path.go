@@ -1,16 +1,26 @@
 package minnow
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"syscall"
 	"time"
 )
 
 type Path string
 
+// tempNamePattern matches the names createTemp gives temporary files, which
+// are left behind if minnow dies before they're renamed into place.
+var tempNamePattern = regexp.MustCompile(`^\..+\.tmp-\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}:\d{9}$`)
+
 func (p Path) Exists() bool {
 	absPath, err := filepath.Abs(string(p))
 
@@ -204,6 +214,36 @@ func (p Path) Age(now time.Time) (time.Duration, error) {
 	return now.Sub(stat.ModTime()), nil
 }
 
+// Size returns the size of the file at p in bytes.
+func (p Path) Size() (int64, error) {
+	stat, err := os.Stat(string(p))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Size(), nil
+}
+
+// SHA256 returns the hex encoded SHA-256 of the file at p.
+func (p Path) SHA256() (string, error) {
+	f, err := os.Open(string(p))
+
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func (p Path) JoinPath(paths ...Path) Path {
 	ret := string(p)
 
@@ -241,22 +281,35 @@ func (p Path) Mkdir() error {
 	return os.Mkdir(string(p), perms)
 }
 
+// WriteBytes replaces the contents of the file at p.  The data is written
+// to a temporary file and synced before being renamed into place, so a
+// crash never leaves p truncated.
 func (p Path) WriteBytes(data []byte) error {
-	outfile, err := os.Create(string(p))
+	outfile, err := createTemp(p.Parent(), p.Name(), 0666)
 
 	if err != nil {
 		return err
 	}
 
-	defer outfile.Close()
-
+	tempPath := Path(outfile.Name())
 	_, err = outfile.Write(data)
 
+	if err == nil {
+		err = outfile.Sync()
+	}
+
+	closeErr := outfile.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+
 	if err != nil {
+		os.Remove(string(tempPath))
 		return err
 	}
 
-	return nil
+	return commitTemp(tempPath, p)
 }
 
 func (p Path) Unlink() error {
@@ -286,3 +339,250 @@ func (p Path) RmdirRecursive() error {
 func (p Path) Rename(target Path) error {
 	return os.Rename(string(p), string(target))
 }
+
+// Move renames p to target.  If they're on different filesystems, p is
+// copied instead, and only removed once the copy has been synced and
+// checked against it, so a crash partway through leaves p intact.
+func (p Path) Move(target Path) error {
+	err := os.Rename(string(p), string(target))
+
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if p.IsDir() {
+		return moveDirAcross(p, target)
+	}
+
+	return moveFileAcross(p, target)
+}
+
+func moveFileAcross(source, target Path) error {
+	err := copyFileVerified(source, target)
+
+	if err != nil {
+		return err
+	}
+
+	err = source.Unlink()
+
+	if err != nil {
+		return err
+	}
+
+	return syncDir(source.Parent())
+}
+
+func moveDirAcross(source, target Path) error {
+	createdTarget := !target.Exists()
+	err := filepath.Walk(string(source), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(string(source), path)
+
+		if err != nil {
+			return err
+		}
+
+		targetPath := target.JoinPath(Path(relativePath))
+
+		if info.IsDir() {
+			return os.MkdirAll(string(targetPath), info.Mode().Perm()|0700)
+		}
+
+		// a temporary file left behind by a crash isn't worth moving
+		if isTempFile(Path(path)) {
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("Cannot move %s to another filesystem", path)
+		}
+
+		// files are only removed once the whole directory is copied
+		return copyFileVerified(Path(path), targetPath)
+	})
+
+	if err != nil {
+		// the source is still complete, so don't leave half a copy
+		if createdTarget && target.IsDir() {
+			target.RmdirRecursive()
+		}
+
+		return err
+	}
+
+	err = syncDir(target.Parent())
+
+	if err != nil {
+		return err
+	}
+
+	err = source.RmdirRecursive()
+
+	if err != nil {
+		return err
+	}
+
+	return syncDir(source.Parent())
+}
+
+// copyFileVerified copies source to destination like copyFileSynced, then
+// checks the copy against the original, removing it if they don't match.
+func copyFileVerified(source, destination Path) error {
+	err := copyFileSynced(source, destination)
+
+	if err != nil {
+		return err
+	}
+
+	sourceSum, err := source.SHA256()
+
+	if err != nil {
+		return err
+	}
+
+	destinationSum, err := destination.SHA256()
+
+	if err != nil {
+		return err
+	}
+
+	if destinationSum != sourceSum {
+		destination.Unlink()
+		return fmt.Errorf("Copy of %s to %s does not match the original", source, destination)
+	}
+
+	return nil
+}
+
+// copyFileSynced copies source to destination through a temporary file,
+// which is synced before being renamed into place.
+func copyFileSynced(source, destination Path) error {
+	return writeFileSynced(source, destination, func(to, from *os.File) error {
+		// between two files, io.Copy uses copy_file_range where it can,
+		// so the data doesn't have to pass through minnow
+		_, err := io.Copy(to, from)
+		return err
+	})
+}
+
+// writeFileSynced fills a temporary file from source, then syncs it and
+// renames it to destination.
+func writeFileSynced(source, destination Path, fill func(to, from *os.File) error) error {
+	from, err := os.Open(string(source))
+
+	if err != nil {
+		return err
+	}
+
+	defer from.Close()
+
+	perms, err := source.Permissions()
+
+	if err != nil {
+		return err
+	}
+
+	// Copy source permissions, making sure that the destination is
+	// at least readable and writable.
+	to, err := createTemp(destination.Parent(), destination.Name(), perms|0600)
+
+	if err != nil {
+		return err
+	}
+
+	tempPath := Path(to.Name())
+	err = fill(to, from)
+
+	if err == nil {
+		err = to.Sync()
+	}
+
+	closeErr := to.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(string(tempPath))
+		return err
+	}
+
+	return commitTemp(tempPath, destination)
+}
+
+// createTemp creates a hidden temporary file in dir, to be renamed to name
+// once it's complete.
+func createTemp(dir Path, name string, perms os.FileMode) (*os.File, error) {
+	for {
+		tempPath := dir.JoinPath(Path(fmt.Sprintf(".%s.tmp-%s", name, nanoTimestamp(time.Now()))))
+		f, err := os.OpenFile(string(tempPath), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perms)
+
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// isTempFile returns true if path is named like a temporary file made by
+// createTemp.
+func isTempFile(path Path) bool {
+	return tempNamePattern.MatchString(path.Name())
+}
+
+// removeTempFiles removes the temporary files left anywhere under dir by
+// writes that never finished.  It should only be called when nothing is
+// writing to dir.
+func removeTempFiles(dir Path) error {
+	return filepath.Walk(string(dir), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// something else may have removed it in the meantime
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if info.Mode().IsRegular() && isTempFile(Path(path)) {
+			return os.Remove(path)
+		}
+
+		return nil
+	})
+}
+
+// commitTemp renames a complete temporary file into place, and syncs the
+// directory so the rename survives a crash.
+func commitTemp(tempPath, target Path) error {
+	err := tempPath.Rename(target)
+
+	if err != nil {
+		os.Remove(string(tempPath))
+		return err
+	}
+
+	return syncDir(target.Parent())
+}
+
+func syncDir(dir Path) error {
+	f, err := os.Open(string(dir))
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	err = f.Sync()
+
+	// some filesystems can't sync directories
+	if errors.Is(err, syscall.EINVAL) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,94 @@
+package minnow
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PathPattern maps the directories a file was dropped off in to metadata.
+// A pattern like {site}/{year}/{month}/* has one segment per directory
+// level, plus one for the file name.  A segment in braces captures that
+// level as a metadata key, and any other segment is a glob the level has
+// to match.
+type PathPattern struct {
+	pattern  string
+	segments []string
+}
+
+func ParsePathPattern(pattern string) (*PathPattern, error) {
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+
+	for _, segment := range segments {
+		if len(segment) == 0 {
+			return nil, fmt.Errorf("Path pattern %s has an empty segment", pattern)
+		}
+
+		if key, isKey := patternKey(segment); isKey {
+			if len(key) == 0 || strings.ContainsAny(key, "={}/ \t") {
+				return nil, fmt.Errorf("Path pattern %s has an invalid key %s", pattern, segment)
+			}
+
+			continue
+		}
+
+		if strings.ContainsAny(segment, "{}") {
+			return nil, fmt.Errorf("Path pattern %s must capture whole segments, not %s", pattern, segment)
+		}
+
+		if _, err := filepath.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("Path pattern %s has an invalid glob %s", pattern, segment)
+		}
+	}
+
+	return &PathPattern{pattern, segments}, nil
+}
+
+func patternKey(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+
+	return "", false
+}
+
+// Match returns the metadata captured from relativePath, the path of a
+// file relative to the directory it was dropped off in.  Returns false if
+// the path doesn't fit the pattern.
+func (pattern *PathPattern) Match(relativePath string) (Properties, bool) {
+	parts := strings.Split(filepath.ToSlash(relativePath), "/")
+
+	if len(parts) != len(pattern.segments) {
+		return nil, false
+	}
+
+	captured := make(Properties)
+
+	for i, segment := range pattern.segments {
+		if key, isKey := patternKey(segment); isKey {
+			// the value has to survive being written as a property
+			if strings.ContainsAny(parts[i], "=\n") {
+				return nil, false
+			}
+
+			captured[key] = parts[i]
+			continue
+		}
+
+		if matched, _ := filepath.Match(segment, parts[i]); !matched {
+			return nil, false
+		}
+	}
+
+	return captured, true
+}
+
+// Depth returns how many directory levels the pattern expects below the
+// directory files are dropped off in.
+func (pattern *PathPattern) Depth() int {
+	return len(pattern.segments) - 1
+}
+
+func (pattern *PathPattern) String() string {
+	return pattern.pattern
+}
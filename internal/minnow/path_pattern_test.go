@@ -0,0 +1,47 @@
+package minnow
+
+import (
+	"testing"
+)
+
+func TestParsePathPattern(t *testing.T) {
+	for _, patternStr := range []string{"{site}/{year}/*", "/incoming/{site}/*.csv/", "*"} {
+		if _, err := ParsePathPattern(patternStr); err != nil {
+			t.Errorf("Expected %s to be valid: %s", patternStr, err.Error())
+		}
+	}
+
+	for _, patternStr := range []string{"{site}//*", "{}/*", "site-{site}/*", "{a b}/*", "[/*"} {
+		if _, err := ParsePathPattern(patternStr); err == nil {
+			t.Errorf("Expected %s to be invalid", patternStr)
+		}
+	}
+}
+
+func TestPathPatternMatch(t *testing.T) {
+	pattern, err := ParsePathPattern("{site}/{year}/{month}/*.csv")
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if pattern.Depth() != 3 {
+		t.Errorf("Expected a depth of 3, got %d", pattern.Depth())
+	}
+
+	captured, matched := pattern.Match("north/2026/10/readings.csv")
+
+	if !matched {
+		t.Fatalf("Expected a match")
+	}
+
+	if captured["site"] != "north" || captured["year"] != "2026" || captured["month"] != "10" || len(captured) != 3 {
+		t.Errorf("Incorrect metadata captured: %v", captured)
+	}
+
+	for _, relativePath := range []string{"north/2026/readings.csv", "north/2026/10/readings.txt", "north/2026/10/11/readings.csv", "a=b/2026/10/readings.csv"} {
+		if _, matched := pattern.Match(relativePath); matched {
+			t.Errorf("Expected %s not to match", relativePath)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package minnow
 
 import (
 	"math/rand"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -172,3 +173,126 @@ func TestJoinPath(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteBytesReplaces(t *testing.T) {
+	dir := Path("/tmp/pathlib-" + randomString(20))
+	dir.Mkdir()
+	p := dir.JoinPath(Path("data"))
+
+	for _, content := range []string{"a longer first version", "short"} {
+		err := p.WriteBytes([]byte(content))
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	content, err := p.ReadBytes()
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if string(content) != "short" {
+		t.Errorf("Read back %q", content)
+	}
+
+	// the temporary file shouldn't be left behind
+	if paths, _ := dir.Glob(".*"); len(paths) != 0 {
+		t.Errorf("Found leftover files %v", paths)
+	}
+}
+
+func TestMoveFileAcross(t *testing.T) {
+	dir := Path("/tmp/pathlib-" + randomString(20))
+	dir.Mkdir()
+	source := dir.JoinPath(Path("source"))
+	target := dir.JoinPath(Path("target"))
+	source.WriteBytes([]byte("some data"))
+
+	// moveFileAcross works on any filesystem, so it's tested directly
+	err := moveFileAcross(source, target)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if source.Exists() {
+		t.Errorf("Source %s was not removed", source)
+	}
+
+	content, err := target.ReadBytes()
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if string(content) != "some data" {
+		t.Errorf("Read back %q", content)
+	}
+}
+
+func TestMoveDirAcross(t *testing.T) {
+	dir := Path("/tmp/pathlib-" + randomString(20))
+	source := dir.JoinPath(Path("source"))
+	target := dir.JoinPath(Path("target"))
+	os.MkdirAll(string(source.JoinPath(Path("nested"))), 0700)
+	source.JoinPath(Path("top")).WriteBytes([]byte("top"))
+	source.JoinPath(Path("nested/inner")).WriteBytes([]byte("inner"))
+	leftover := ".inner.tmp-" + nanoTimestamp(time.Now())
+	source.JoinPath(Path("nested"), Path(leftover)).WriteBytes([]byte("partial"))
+	err := moveDirAcross(source, target)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if source.Exists() {
+		t.Errorf("Source %s was not removed", source)
+	}
+
+	for name, expected := range map[string]string{"top": "top", "nested/inner": "inner"} {
+		content, err := target.JoinPath(Path(name)).ReadBytes()
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if string(content) != expected {
+			t.Errorf("Read back %q from %s", content, name)
+		}
+	}
+
+	if target.JoinPath(Path("nested"), Path(leftover)).Exists() {
+		t.Errorf("Leftover temporary file should not be moved")
+	}
+}
+
+func TestRemoveTempFiles(t *testing.T) {
+	dir := Path("/tmp/pathlib-" + randomString(20))
+	os.MkdirAll(string(dir.JoinPath(Path("nested"))), 0700)
+	defer dir.RmdirRecursive()
+	leftover := dir.JoinPath(Path("nested"), Path(".data.tmp-"+nanoTimestamp(time.Now())))
+	leftover.WriteBytes([]byte("partial"))
+	kept := []Path{dir.JoinPath(Path("data")), dir.JoinPath(Path(".hidden")), dir.JoinPath(Path(".data.tmp-soon"))}
+
+	for _, path := range kept {
+		path.WriteBytes([]byte("data"))
+	}
+
+	err := removeTempFiles(dir)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if leftover.Exists() {
+		t.Errorf("Temporary file %s was not removed", leftover)
+	}
+
+	for _, path := range kept {
+		if !path.Exists() {
+			t.Errorf("%s should not have been removed", path)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package minnow
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ProcessGroups keeps track of the process groups of running start
+// scripts, so the scripts and any children they spawned can be signaled
+// together.  Each group is led by its start script.
+type ProcessGroups struct {
+	leaders map[int]*os.Process
+	mutex   *sync.Mutex
+}
+
+func NewProcessGroups() *ProcessGroups {
+	return &ProcessGroups{make(map[int]*os.Process), new(sync.Mutex)}
+}
+
+func (groups *ProcessGroups) Add(leader *os.Process) {
+	groups.mutex.Lock()
+	defer groups.mutex.Unlock()
+
+	groups.leaders[leader.Pid] = leader
+}
+
+func (groups *ProcessGroups) Remove(leader *os.Process) {
+	groups.mutex.Lock()
+	defer groups.mutex.Unlock()
+
+	delete(groups.leaders, leader.Pid)
+}
+
+func (groups *ProcessGroups) Len() int {
+	groups.mutex.Lock()
+	defer groups.mutex.Unlock()
+
+	return len(groups.leaders)
+}
+
+func (groups *ProcessGroups) Signal(sig syscall.Signal) {
+	groups.mutex.Lock()
+	defer groups.mutex.Unlock()
+
+	for _, leader := range groups.leaders {
+		signalGroup(leader, sig)
+	}
+}
+
+// killGroupAfter sends SIGTERM to a process group once timeout expires,
+// then SIGKILL if the group is still around after killGracePeriod.  The
+// returned function must be called once the process exits, and reports
+// whether the timeout fired.
+func killGroupAfter(leader *os.Process, timeout time.Duration) func() bool {
+	var fired int32
+	exited := make(chan struct{})
+
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&fired, 1)
+		signalGroup(leader, syscall.SIGTERM)
+
+		select {
+		case <-exited:
+		case <-time.After(killGracePeriod):
+			signalGroup(leader, syscall.SIGKILL)
+		}
+	})
+
+	return func() bool {
+		timer.Stop()
+		close(exited)
+
+		if atomic.LoadInt32(&fired) == 1 {
+			// clean up any children that outlived the start script
+			signalGroup(leader, syscall.SIGKILL)
+			return true
+		}
+
+		return false
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package minnow
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Without process groups, commands start normally, and only the start
+// script itself can be stopped.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// signalGroup kills the start script, whatever sig is, since there's no
+// portable way to ask it to exit.
+func signalGroup(leader *os.Process, sig syscall.Signal) error {
+	return leader.Kill()
+}
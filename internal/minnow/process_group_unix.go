@@ -0,0 +1,20 @@
+//go:build unix
+
+package minnow
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd start in its own process group, so it can be
+// signaled along with any children it spawns.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalGroup(leader *os.Process, sig syscall.Signal) error {
+	// a negative pid signals every process in the group
+	return syscall.Kill(-leader.Pid, sig)
+}
@@ -0,0 +1,89 @@
+//go:build unix
+
+package minnow
+
+import (
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProcessorRegistryShutdown(t *testing.T) {
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	workPath.Mkdir()
+	defer workPath.RmdirRecursive()
+
+	// the start script records its process group, then waits on a child
+	// that would outlive it if only the script were signaled
+	pgidPath := workPath.JoinPath("pgid")
+	definitionsPath := workPath.JoinPath("processors")
+	definitionsPath.Mkdir()
+	makeTestProcessorDefinition(t, "pool_size = 1", "echo $$ > "+string(pgidPath)+"\nsleep 30 &\nwait").Rename(definitionsPath.JoinPath("test"))
+	registry := makeTestRegistry(t, definitionsPath, workPath)
+	defer registry.Shutdown(0)
+
+	var processorId ProcessorId
+	var runRequestQueue *RunRequestQueue
+
+	for id, queue := range registry.runRequestQueues {
+		processorId, runRequestQueue = id, queue
+	}
+
+	inputPath := workPath.JoinPath("input")
+	outputPath := workPath.JoinPath("output")
+	inputPath.Mkdir()
+	outputPath.Mkdir()
+	WriteWorkState(inputPath, WorkState{WorkKindRun, RunStatusQueued, processorId, make([]ProcessorId, 0), inputPath, outputPath, 0, make([]Path, 0), 0, ""})
+	err := registry.SendToProcessorId(processorId, RunRequest{inputPath, outputPath, make([]ProcessorId, 0), registry.ingestDirQueue, 0, time.Time{}})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	deadline := time.Now().Add(time.Duration(5) * time.Second)
+
+	for !pgidPath.Exists() && time.Now().Before(deadline) {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+
+	pgidBytes, err := pgidPath.ReadBytes()
+
+	if err != nil {
+		t.Fatalf("Start script never ran: %s", err.Error())
+	}
+
+	pgid, err := strconv.Atoi(strings.TrimSpace(string(pgidBytes)))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	started := time.Now()
+	registry.Shutdown(time.Duration(100) * time.Millisecond)
+
+	if time.Since(started) > killGracePeriod {
+		t.Errorf("Shutdown took %s, so SIGTERM didn't stop the command", time.Since(started))
+	}
+
+	// the orphaned sleep may take a moment to be reaped
+	deadline = time.Now().Add(time.Duration(5) * time.Second)
+
+	for syscall.Kill(-pgid, 0) == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+
+	if syscall.Kill(-pgid, 0) == nil {
+		t.Errorf("Process group %d still exists after shutdown", pgid)
+	}
+
+	// the interrupted run stays queued, to be run again at startup
+	if runRequestQueue.Len() != 1 || !registry.QueuedInputDirs()[inputPath] || !inputPath.IsDir() {
+		t.Errorf("Interrupted run should have been left in its queue")
+	}
+
+	if state, err := ReadWorkState(inputPath); err != nil || state.Status != RunStatusQueued {
+		t.Errorf("Interrupted run should be %s, not %s", RunStatusQueued, state.Status)
+	}
+}
@@ -1,33 +1,86 @@
 package minnow
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
 type ProcessorId Path
 
+const (
+	// Default exit codes for the processor exit code contract.  The retry
+	// and fail codes come from EX_TEMPFAIL and EX_DATAERR in sysexits.h.
+	DefaultSkipExitCode  = 3
+	DefaultRetryExitCode = 75
+	DefaultFailExitCode  = 65
+
+	FailureGeneric   = "generic"   // retried according to the retry policy
+	FailureTransient = "transient" // retried up to max_transient_retries times
+	FailurePermanent = "permanent" // never retried
+)
+
+// RunError is returned by RunCommand when a run fails, and says how the
+// failure should be handled.
+type RunError struct {
+	Kind     string
+	ExitCode int // -1 if the command didn't exit normally
+	Started  time.Time
+	Finished time.Time
+	Err      error
+}
+
+func (runError *RunError) Error() string {
+	return fmt.Sprintf("%s failure: %s", runError.Kind, runError.Err.Error())
+}
+
 type Processor struct {
 	name           string
 	definitionPath Path
-	startScript    string
-	hook           Hook
-	poolSize       int
+	fingerprint    string
+	config         ProcessorConfig
+	processGroups  *ProcessGroups
 	logger         *log.Logger
 }
 
 type ProcessorConfig struct {
 	StartScript string
-	Hook        Hook
+	Hooks       Hooks
 	PoolSize    int
+	Timeout     time.Duration // zero means no timeout
+	MaxRetries  int
+	// retries of transient failures, which don't count toward MaxRetries
+	MaxTransientRetries int
+	RetryBackoff        time.Duration
+	RetryMaxBackoff     time.Duration
+	SkipExitCodes       []int
+	RetryExitCodes      []int
+	FailExitCodes       []int
+	// consecutive failures before the circuit opens, zero to disable
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
 }
 
-func NewProcessor(definitionPath Path) (Processor, error) {
+// ProcessorDefaults holds settings from the main config that apply to
+// every processor unless its own config overrides them.
+type ProcessorDefaults struct {
+	Timeout                 time.Duration
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+}
+
+func NewProcessor(definitionPath Path, defaults ProcessorDefaults) (Processor, error) {
 	if !definitionPath.IsDir() {
 		return Processor{}, fmt.Errorf("Processor definition path must be a directory: %s", definitionPath)
 	}
@@ -38,7 +91,13 @@ func NewProcessor(definitionPath Path) (Processor, error) {
 		return Processor{}, fmt.Errorf("Processor config file does not exist: %s", configPath)
 	}
 
-	config, err := parseProcessorConfig(configPath, definitionPath)
+	fingerprint, err := definitionFingerprint(definitionPath)
+
+	if err != nil {
+		return Processor{}, err
+	}
+
+	config, err := parseProcessorConfig(configPath, definitionPath, defaults)
 
 	if err != nil {
 		return Processor{}, err
@@ -46,10 +105,45 @@ func NewProcessor(definitionPath Path) (Processor, error) {
 
 	name := definitionPath.Name()
 	logger := log.New(os.Stdout, name+": ", 0)
-	return Processor{name, definitionPath, config.StartScript, config.Hook, config.PoolSize, logger}, nil
+	return Processor{name, definitionPath, fingerprint, config, NewProcessGroups(), logger}, nil
+}
+
+// definitionFingerprint hashes the names, permissions, and contents of
+// everything in a processor's definition directory, so the registry can
+// tell when the definition actually changed.
+func definitionFingerprint(definitionPath Path) (string, error) {
+	hash := sha256.New()
+
+	err := filepath.Walk(string(definitionPath), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hash, "%s\x00%s\x00", path, info.Mode())
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+		_, err = io.Copy(hash, file)
+		return err
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-func parseProcessorConfig(configPath, definitionPath Path) (ProcessorConfig, error) {
+func parseProcessorConfig(configPath, definitionPath Path, defaults ProcessorDefaults) (ProcessorConfig, error) {
 	configProperties, err := PropertiesFromFile(configPath)
 
 	if err != nil {
@@ -83,36 +177,249 @@ func parseProcessorConfig(configPath, definitionPath Path) (ProcessorConfig, err
 		}
 	}
 
-	hookPathString, found := configProperties["hook_file"]
+	timeout, err := parseSeconds(configProperties, "timeout", defaults.Timeout)
 
-	if !found {
-		return ProcessorConfig{}, fmt.Errorf("Processor config missing hook_file property")
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	maxRetries, err := parseNonNegativeInt(configProperties, "max_retries", 0)
+
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	maxTransientRetries, err := parseNonNegativeInt(configProperties, "max_transient_retries", 10)
+
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	retryBackoff, err := parseSeconds(configProperties, "retry_backoff", time.Duration(10)*time.Second)
+
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	retryMaxBackoff, err := parseSeconds(configProperties, "retry_max_backoff", time.Duration(10)*time.Minute)
+
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	skipExitCodes, err := parseExitCodes(configProperties, "skip_exit_codes", DefaultSkipExitCode)
+
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	retryExitCodes, err := parseExitCodes(configProperties, "retry_exit_codes", DefaultRetryExitCode)
+
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	failExitCodes, err := parseExitCodes(configProperties, "fail_exit_codes", DefaultFailExitCode)
+
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	err = checkExitCodesDistinct(skipExitCodes, retryExitCodes, failExitCodes)
+
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	circuitBreakerThreshold, err := parseNonNegativeInt(configProperties, "circuit_breaker_threshold", defaults.CircuitBreakerThreshold)
+
+	if err != nil {
+		return ProcessorConfig{}, err
+	}
+
+	circuitBreakerCooldown, err := parseSeconds(configProperties, "circuit_breaker_cooldown", defaults.CircuitBreakerCooldown)
+
+	if err != nil {
+		return ProcessorConfig{}, err
 	}
 
-	hookPath, err := definitionPath.JoinPath(Path(hookPathString)).Resolve()
+	hookNames, err := hookFileNames(configProperties, definitionPath)
 
 	if err != nil {
 		return ProcessorConfig{}, err
 	}
 
-	// handle hook_type last since it defaults to fail
+	// handle hook_type last since it defaults to fail.  Each hook file can
+	// also name its own.
 	hookType, found := configProperties["hook_type"]
 
 	if !found {
-		hookType = "basicpropertiesmatchhook"
+		hookType = BasicPropertiesMatchHookType
 	}
 
-	if hookType == "basicpropertiesmatchhook" {
-		hook, err := NewBasicPropertiesMatchHookFromFile(hookPath)
+	hooks := make(Hooks, 0, len(hookNames))
+
+	for _, hookName := range hookNames {
+		hookPath, err := definitionPath.JoinPath(Path(hookName)).Resolve()
+
+		if err != nil {
+			return ProcessorConfig{}, err
+		}
+
+		hook, err := LoadHook(hookName, hookType, hookPath)
 
 		if err != nil {
 			return ProcessorConfig{}, err
 		}
 
-		return ProcessorConfig{startScript, hook, poolSize}, nil
+		hooks = append(hooks, hook)
+	}
+
+	return ProcessorConfig{
+		startScript,
+		hooks,
+		poolSize,
+		timeout,
+		maxRetries,
+		maxTransientRetries,
+		retryBackoff,
+		retryMaxBackoff,
+		skipExitCodes,
+		retryExitCodes,
+		failExitCodes,
+		circuitBreakerThreshold,
+		circuitBreakerCooldown,
+	}, nil
+}
+
+// hookFileNames lists the hook files named in hook_file, or the files in
+// the hooks directory if there's no hook_file, relative to definitionPath.
+// Directories in hook_file stand for the files in them.
+func hookFileNames(configProperties Properties, definitionPath Path) ([]string, error) {
+	entries := make([]string, 0)
+	hookFileStr, found := configProperties["hook_file"]
+
+	if found {
+		for _, entry := range splitList(hookFileStr) {
+			entries = append(entries, filepath.Clean(entry))
+		}
+	} else if definitionPath.JoinPath(Path(HooksDirName)).IsDir() {
+		entries = append(entries, HooksDirName)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("Processor config missing hook_file property, and there is no %s directory", HooksDirName)
+	}
+
+	hookNames := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		entryPath := definitionPath.JoinPath(Path(entry))
+
+		if !entryPath.IsDir() {
+			hookNames = append(hookNames, entry)
+			continue
+		}
+
+		paths, err := entryPath.Glob("*")
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			// skip things like editor swap files
+			if path.IsDir() || strings.HasPrefix(path.Name(), ".") {
+				continue
+			}
+
+			hookNames = append(hookNames, filepath.Join(entry, path.Name()))
+		}
+	}
+
+	if len(hookNames) == 0 {
+		return nil, fmt.Errorf("No hook files found for processor %s", definitionPath)
+	}
+
+	return hookNames, nil
+}
+
+func parseExitCodes(properties Properties, key string, defaultCode int) ([]int, error) {
+	codesStr, found := properties[key]
+
+	if !found {
+		return []int{defaultCode}, nil
+	}
+
+	codes := make([]int, 0)
+
+	for _, codeStr := range strings.Split(codesStr, ",") {
+		codeStr = strings.TrimSpace(codeStr)
+
+		if len(codeStr) == 0 {
+			continue
+		}
+
+		code, err := strconv.Atoi(codeStr)
+
+		if err != nil || code < 1 || code > 255 {
+			return nil, fmt.Errorf("%s must be a comma separated list of exit codes from 1 to 255", key)
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+func checkExitCodesDistinct(codeLists ...[]int) error {
+	seen := make(map[int]bool)
+
+	for _, codes := range codeLists {
+		for _, code := range codes {
+			if seen[code] {
+				return fmt.Errorf("Exit code %d is used for more than one outcome", code)
+			}
+
+			seen[code] = true
+		}
+	}
+
+	return nil
+}
+
+func containsExitCode(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// classifyFailure maps the error from a failed command onto the exit code
+// contract.  Returns nil if the command asked to be skipped.
+func (processor Processor) classifyFailure(err error) *RunError {
+	exitErr, ok := err.(*exec.ExitError)
+
+	if !ok || exitErr.ExitCode() < 0 {
+		// didn't start, was killed, or timed out
+		return &RunError{FailureGeneric, -1, time.Time{}, time.Time{}, err}
 	}
 
-	return ProcessorConfig{}, fmt.Errorf("Unknown hook_type %s", hookType)
+	code := exitErr.ExitCode()
+
+	switch {
+	case containsExitCode(processor.config.SkipExitCodes, code):
+		return nil
+	case containsExitCode(processor.config.RetryExitCodes, code):
+		return &RunError{FailureTransient, code, time.Time{}, time.Time{}, err}
+	case containsExitCode(processor.config.FailExitCodes, code):
+		return &RunError{FailurePermanent, code, time.Time{}, time.Time{}, err}
+	}
+
+	return &RunError{FailureGeneric, code, time.Time{}, time.Time{}, err}
 }
 
 func (processor Processor) GetId() ProcessorId {
@@ -123,23 +430,61 @@ func (processor Processor) GetName() string {
 	return processor.name
 }
 
-func (processor Processor) GetPoolSize() int {
-	return processor.poolSize
+func (processor Processor) GetFingerprint() string {
+	return processor.fingerprint
 }
 
-func (processor Processor) Run(runRequestQueue chan RunRequest) {
-	for runRequest := range runRequestQueue {
-		processor.RunCommand(runRequest)
-	}
+func (processor Processor) GetPoolSize() int {
+	return processor.config.PoolSize
 }
 
 func (processor Processor) RunCommand(runRequest RunRequest) error {
-	cmd := exec.Command("./"+processor.startScript, string(runRequest.InputPath), string(runRequest.OutputPath))
+	// A run that was interrupted by a restart may have left partial
+	// output behind, so always start with an empty output directory.
+	err := clearDir(runRequest.OutputPath)
+
+	if err != nil {
+		processor.logger.Printf("Could not clear output path %s: %s", runRequest.OutputPath, err.Error())
+		return err
+	}
+
+	cmd := exec.Command("./"+processor.config.StartScript, string(runRequest.InputPath), string(runRequest.OutputPath))
 	cmd.Dir = string(processor.definitionPath) // set the working directory for the command
+
+	// Run the command in its own process group so it can be signaled
+	// along with any children it spawns.
+	setProcessGroup(cmd)
+	var stdoutStderr bytes.Buffer
+	cmd.Stdout = &stdoutStderr
+	cmd.Stderr = &stdoutStderr
+
 	processor.logger.Printf("Processor %s running %s", processor.name, cmd.String())
-	stdoutStderr, err := cmd.CombinedOutput()
+	processor.updateRunStatus(runRequest, RunStatusRunning)
+	started := time.Now()
+	err = cmd.Start()
+
+	if err == nil {
+		processor.processGroups.Add(cmd.Process)
+
+		if processor.config.Timeout > 0 {
+			stopTimeout := killGroupAfter(cmd.Process, processor.config.Timeout)
+			err = cmd.Wait()
+
+			if stopTimeout() {
+				err = fmt.Errorf("Killed after exceeding timeout of %s", processor.config.Timeout)
+				stdoutStderr.WriteString(fmt.Sprintf("\nminnow: %s\n", err.Error()))
+			}
+		} else {
+			err = cmd.Wait()
+		}
+
+		processor.processGroups.Remove(cmd.Process)
+	}
+
+	finished := time.Now()
+
 	processorOutputPath := runRequest.OutputPath.JoinPath(Path(fmt.Sprintf("_%s_output.txt", processor.name)))
-	outputErr := processorOutputPath.WriteBytes(stdoutStderr)
+	outputErr := processorOutputPath.WriteBytes(stdoutStderr.Bytes())
 
 	if outputErr != nil {
 		processor.logger.Printf("Processor %s could not write stdout/stderr to %s: %s", processor.name, processorOutputPath, outputErr.Error())
@@ -147,22 +492,148 @@ func (processor Processor) RunCommand(runRequest RunRequest) error {
 	}
 
 	if err != nil {
-		processor.logger.Printf("Processor %s returned error: %s", processor.name, err.Error())
-		return err
+		runError := processor.classifyFailure(err)
+
+		if runError == nil {
+			processor.logger.Printf("Processor %s skipped %s", processor.name, runRequest.InputPath)
+			processor.removeRun(runRequest, true)
+			return nil
+		}
+
+		runError.Started = started
+		runError.Finished = finished
+
+		// the caller decides whether this is retried or has failed
+		processor.logger.Printf("Processor %s returned error: %s", processor.name, runError.Error())
+		return runError
 	}
 
 	processor.logger.Print("Processor completed successfully")
+	processor.updateRunStatus(runRequest, RunStatusSucceeded)
 	processedBy := append(runRequest.ProcessedBy, processor.GetId())
-	runRequest.IngestDirChan <- IngestDirInfo{runRequest.OutputPath, time.Duration(0), processedBy, true}
-	err = runRequest.InputPath.RmdirRecursive() // make sure the input directory gets removed
+	err = runRequest.IngestDirQueue.Put(IngestDirInfo{runRequest.OutputPath, time.Duration(0), processedBy, true, ""})
 
 	if err != nil {
-		processor.logger.Printf("Could not remove input path: %s", err.Error())
+		// leave the input in place so the run can be recovered
+		processor.logger.Printf("Could not queue output %s for ingest: %s", runRequest.OutputPath, err.Error())
+		return err
 	}
 
+	processor.removeRun(runRequest, false)
 	return nil
 }
 
-func (processor Processor) HookMatches(properties Properties) bool {
-	return processor.hook.Matches(properties)
+// removeRun cleans up after a run that is finished with, optionally
+// removing its output directory as well.
+func (processor Processor) removeRun(runRequest RunRequest, removeOutput bool) {
+	err := runRequest.InputPath.RmdirRecursive() // make sure the input directory gets removed
+
+	if err != nil {
+		processor.logger.Printf("Could not remove input path: %s", err.Error())
+	}
+
+	if removeOutput {
+		err = runRequest.OutputPath.RmdirRecursive()
+
+		if err != nil {
+			processor.logger.Printf("Could not remove output path: %s", err.Error())
+		}
+	}
+
+	err = RemoveWorkState(runRequest.InputPath)
+
+	if err != nil {
+		processor.logger.Printf("Could not remove run state: %s", err.Error())
+	}
+}
+
+// RetryBackoff returns how long to wait before the given retry attempt,
+// doubling each time up to the configured maximum.
+func (processor Processor) RetryBackoff(attempt int) time.Duration {
+	backoff := processor.config.RetryBackoff
+
+	for i := 1; i < attempt && backoff < processor.config.RetryMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > processor.config.RetryMaxBackoff {
+		backoff = processor.config.RetryMaxBackoff
+	}
+
+	return backoff
+}
+
+// PrepareRetry sets up the next attempt of a failed run with a fresh
+// output directory.  The failed attempt's output directory is left alone
+// so its stdout/stderr can be looked at later.  Returns false if the run
+// is out of retries, or failed permanently.
+//
+// Transient failures are tried again after retry_backoff, with the same
+// attempt number, up to max_transient_retries times.
+func (processor Processor) PrepareRetry(runRequest RunRequest, failureKind string) (RunRequest, bool, error) {
+	if failureKind == FailurePermanent {
+		return runRequest, false, nil
+	}
+
+	state, err := ReadWorkState(runRequest.InputPath)
+
+	if err != nil {
+		return runRequest, false, err
+	}
+
+	retryRequest := runRequest
+
+	if failureKind == FailureTransient {
+		if state.TransientRetries >= processor.config.MaxTransientRetries {
+			return runRequest, false, nil
+		}
+
+		state.TransientRetries++
+		retryRequest.NotBefore = time.Now().Add(processor.config.RetryBackoff)
+	} else {
+		if runRequest.Attempt >= processor.config.MaxRetries {
+			return runRequest, false, nil
+		}
+
+		retryRequest.Attempt++
+		retryRequest.NotBefore = time.Now().Add(processor.RetryBackoff(retryRequest.Attempt))
+	}
+
+	outputPath, err := makeRandomPath(runRequest.OutputPath.Parent(), processor.name+"-output")
+
+	if err != nil {
+		return runRequest, false, err
+	}
+
+	retryRequest.OutputPath = outputPath
+	state.Status = RunStatusQueued
+	state.FailedOutputPaths = append(state.FailedOutputPaths, runRequest.OutputPath)
+	state.OutputPath = retryRequest.OutputPath
+	state.Attempt = retryRequest.Attempt
+	err = WriteWorkState(runRequest.InputPath, state)
+
+	if err != nil {
+		return runRequest, false, err
+	}
+
+	return retryRequest, true, nil
+}
+
+func (processor Processor) updateRunStatus(runRequest RunRequest, status string) {
+	err := UpdateRunStatus(runRequest.InputPath, status)
+
+	if err != nil {
+		processor.logger.Printf("Could not update run state for %s: %s", runRequest.InputPath, err.Error())
+	}
+}
+
+func (processor Processor) SignalRunning(sig syscall.Signal) {
+	processor.processGroups.Signal(sig)
+}
+
+// MatchingHook returns the name of the first of the processor's hooks that
+// matches properties or the data sampler reads.  Returns false if none of
+// them do.
+func (processor Processor) MatchingHook(properties Properties, sampler *DataSampler) (string, bool) {
+	return processor.config.Hooks.Match(properties, sampler)
 }
@@ -1,26 +1,297 @@
 package minnow
 
+import (
+	"sync"
+	"syscall"
+	"time"
+)
+
 type ProcessorPool struct {
 	processor       Processor
-	runRequestQueue chan RunRequest
+	runRequestQueue *RunRequestQueue
+	quarantine      *Quarantine
+	breaker         *CircuitBreaker
+	stopChan        chan struct{} // closed once the pool stops starting RunRequests
+	haltChan        chan struct{} // closed once minnow is shutting down
+	drainChan       chan struct{} // closed once the pool should exit when its queue is empty
+	wakeChan        chan struct{} // closed by Stop or Drain to wake up waiting workers
+	stopOnce        *sync.Once
+	haltOnce        *sync.Once
+	drainOnce       *sync.Once
+	wakeOnce        *sync.Once
+	workers         *sync.WaitGroup
+	doneChan        chan struct{} // closed once all workers have exited
 }
 
-func NewProcessorPool(processor Processor, poolSize int) *ProcessorPool {
-	runRequestQueue := make(chan RunRequest, 100*poolSize)
+func NewProcessorPool(processor Processor, poolSize int, runRequestQueue *RunRequestQueue, quarantine *Quarantine, breaker *CircuitBreaker) *ProcessorPool {
+	pool := &ProcessorPool{
+		processor,
+		runRequestQueue,
+		quarantine,
+		breaker,
+		make(chan struct{}),
+		make(chan struct{}),
+		make(chan struct{}),
+		make(chan struct{}),
+		new(sync.Once),
+		new(sync.Once),
+		new(sync.Once),
+		new(sync.Once),
+		new(sync.WaitGroup),
+		make(chan struct{}),
+	}
 
 	for i := 0; i < poolSize; i++ {
-		go processor.Run(runRequestQueue)
+		pool.workers.Add(1)
+		go pool.runWorker()
+	}
+
+	go func() {
+		pool.workers.Wait()
+		close(pool.doneChan)
+	}()
+
+	return pool
+}
+
+func (pool *ProcessorPool) halted() bool {
+	select {
+	case <-pool.haltChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (pool *ProcessorPool) draining() bool {
+	select {
+	case <-pool.drainChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (pool *ProcessorPool) stopped() bool {
+	select {
+	case <-pool.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// take returns the next RunRequest.  Returns ErrQueueCanceled once the
+// pool is stopped, or ErrQueueEmpty once a draining pool runs out of work.
+func (pool *ProcessorPool) take() (RunRequest, SpoolEntry, error) {
+	if !pool.draining() {
+		runRequest, entry, err := pool.runRequestQueue.Take(pool.wakeChan)
+
+		if err != ErrQueueCanceled {
+			return runRequest, entry, err
+		}
+	}
+
+	if pool.stopped() {
+		return RunRequest{}, SpoolEntry{}, ErrQueueCanceled
+	}
+
+	return pool.runRequestQueue.TryTake()
+}
+
+func (pool *ProcessorPool) runWorker() {
+	defer pool.workers.Done()
+
+	for {
+		runRequest, entry, err := pool.take()
+
+		if err == ErrQueueCanceled || err == ErrQueueEmpty {
+			return
+		}
+
+		if err != nil {
+			pool.processor.logger.Printf("Discarding run entry: %s", err.Error())
+			pool.runRequestQueue.Ack(entry)
+			continue
+		}
+
+		if runRequest.NotBefore.After(time.Now()) {
+			if !pool.draining() {
+				pool.deferRun(runRequest, entry)
+				continue
+			}
+
+			// a draining pool has nothing to hand the retry off to
+			select {
+			case <-time.After(time.Until(runRequest.NotBefore)):
+			case <-pool.stopChan:
+				pool.runRequestQueue.Release(entry)
+				return
+			}
+		}
+
+		// hold on to the run while the circuit is open
+		if !pool.breaker.Wait(pool.stopChan) {
+			pool.runRequestQueue.Release(entry)
+			return
+		}
+
+		err = pool.processor.RunCommand(runRequest)
+		pool.recordResult(err)
+
+		if err != nil {
+			pool.handleFailure(runRequest, entry, err)
+			continue
+		}
+
+		err = pool.runRequestQueue.Ack(entry)
+
+		if err != nil {
+			pool.processor.logger.Print(err.Error())
+		}
+	}
+}
+
+// recordResult tells the circuit breaker how a run went.  Only failures
+// that point at the processor itself count against it.
+func (pool *ProcessorPool) recordResult(err error) {
+	if err == nil {
+		pool.breaker.Succeeded()
+		return
+	}
+
+	runError, ok := err.(*RunError)
+
+	if !ok || runError.Kind == FailureTransient || pool.halted() {
+		pool.breaker.Abandoned()
+		return
 	}
 
-	return &ProcessorPool{processor, runRequestQueue}
+	pool.breaker.Failed()
+}
+
+// deferRun holds on to a RunRequest that isn't due yet without tying up
+// a worker.  The entry stays in the queue until it's put back, so it
+// isn't lost if minnow stops in the meantime.
+func (pool *ProcessorPool) deferRun(runRequest RunRequest, entry SpoolEntry) {
+	time.AfterFunc(time.Until(runRequest.NotBefore), func() {
+		err := pool.runRequestQueue.Put(runRequest)
+
+		if err != nil {
+			pool.processor.logger.Printf("Could not re-queue %s: %s", runRequest.InputPath, err.Error())
+			return
+		}
+
+		pool.runRequestQueue.Ack(entry)
+	})
 }
 
-func (pool *ProcessorPool) Run(runRequest RunRequest) {
-	pool.runRequestQueue <- runRequest
+func (pool *ProcessorPool) handleFailure(runRequest RunRequest, entry SpoolEntry, runErr error) {
+	if pool.halted() {
+		// The command was most likely killed by the shutdown, so
+		// leave the entry in the queue to be run again at startup.
+		UpdateRunStatus(runRequest.InputPath, RunStatusQueued)
+		return
+	}
+
+	failureKind := FailureGeneric
+
+	if runError, ok := runErr.(*RunError); ok {
+		failureKind = runError.Kind
+	}
+
+	retryRequest, retry, err := pool.processor.PrepareRetry(runRequest, failureKind)
+
+	if err != nil {
+		pool.processor.logger.Printf("Could not set up retry of %s: %s", runRequest.InputPath, err.Error())
+	}
+
+	if retry {
+		err = pool.runRequestQueue.Put(retryRequest)
+
+		if err == nil {
+			if failureKind == FailureTransient {
+				pool.processor.logger.Printf("Trying %s again in %s", runRequest.InputPath,
+					time.Until(retryRequest.NotBefore).Round(time.Second))
+			} else {
+				pool.processor.logger.Printf("Retrying %s in %s (attempt %d of %d)", runRequest.InputPath,
+					time.Until(retryRequest.NotBefore).Round(time.Second), retryRequest.Attempt, pool.processor.config.MaxRetries)
+			}
+
+			pool.runRequestQueue.Ack(entry)
+			return
+		}
+
+		pool.processor.logger.Printf("Could not queue retry of %s: %s", runRequest.InputPath, err.Error())
+	}
+
+	UpdateRunStatus(runRequest.InputPath, RunStatusFailed)
+	pool.quarantineRun(runRequest, runErr)
+	pool.runRequestQueue.Ack(entry)
 }
 
+// quarantineRun moves a run that has failed for good out of the work path.
+// If that fails, the run is left in place, and recovery tries again at the
+// next startup.
+func (pool *ProcessorPool) quarantineRun(runRequest RunRequest, runErr error) {
+	report := FailureReport{pool.GetProcessorName(), -1, time.Time{}, time.Time{}, runRequest.Attempt + 1, runErr.Error()}
+
+	if runError, ok := runErr.(*RunError); ok {
+		report.ExitCode = runError.ExitCode
+		report.Started = runError.Started
+		report.Finished = runError.Finished
+	}
+
+	_, err := pool.quarantine.Add(runRequest.InputPath, report)
+
+	if err != nil {
+		pool.processor.logger.Printf("Could not quarantine failed run %s: %s", runRequest.InputPath, err.Error())
+	}
+}
+
+// Stop prevents the pool from starting queued RunRequests.  Queued
+// requests stay in the RunRequestQueue, which is shared with any pool
+// that replaces this one.
 func (pool *ProcessorPool) Stop() {
-	close(pool.runRequestQueue)
+	pool.stopOnce.Do(func() { close(pool.stopChan) })
+	pool.wakeOnce.Do(func() { close(pool.wakeChan) })
+}
+
+// Drain lets the pool's workers run whatever is left in its queue, then
+// exit.  It's used when a processor is removed, since no other pool will
+// pick up its queue.
+func (pool *ProcessorPool) Drain() {
+	pool.drainOnce.Do(func() { close(pool.drainChan) })
+	pool.wakeOnce.Do(func() { close(pool.wakeChan) })
+}
+
+// Halt stops the pool because minnow is shutting down.  Commands that
+// fail from here on are left queued so they run again at startup.
+func (pool *ProcessorPool) Halt() {
+	pool.Stop()
+	pool.haltOnce.Do(func() { close(pool.haltChan) })
+}
+
+// Wait blocks until all of the pool's workers have exited, or until the
+// timeout expires.  Returns true if the workers exited.
+func (pool *ProcessorPool) Wait(timeout time.Duration) bool {
+	select {
+	case <-pool.doneChan:
+		return true
+	default:
+	}
+
+	select {
+	case <-pool.doneChan:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Signal sends sig to the process groups of all running commands.
+func (pool *ProcessorPool) Signal(sig syscall.Signal) {
+	pool.processor.SignalRunning(sig)
 }
 
 func (pool *ProcessorPool) GetProcessorName() string {
@@ -31,6 +302,6 @@ func (pool *ProcessorPool) GetProcessorId() ProcessorId {
 	return pool.processor.GetId()
 }
 
-func (pool *ProcessorPool) ProcessorHookMatches(properties Properties) bool {
-	return pool.processor.HookMatches(properties)
+func (pool *ProcessorPool) ProcessorMatchingHook(properties Properties, sampler *DataSampler) (string, bool) {
+	return pool.processor.MatchingHook(properties, sampler)
 }
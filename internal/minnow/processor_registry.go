@@ -5,21 +5,69 @@ import (
 	"log"
 	"os"
 	"sync"
+	"syscall"
 	"time"
 )
 
+const (
+	// what happens to a new run when its processor's queue is full
+	OverflowBlock  = "block"  // queue it anyway, and pause ingest until there's room
+	OverflowReject = "reject" // move it to the quarantine instead
+
+	// how long to wait for commands to exit after signaling them
+	killGracePeriod = time.Duration(5) * time.Second
+
+	// how long processor_definitions_dir has to be quiet before reloading,
+	// so copying in a definition only reloads it once
+	reloadDebounce = time.Duration(1) * time.Second
+)
+
 type ProcessorRegistry struct {
-	definitionsPath Path
-	processorPools  map[ProcessorId]*ProcessorPool
-	mutex           *sync.RWMutex
-	logger          *log.Logger
+	definitionsPath   Path
+	processorDefaults ProcessorDefaults
+	spoolPath         Path
+	queueCapacity     int
+	queueOverflow     string
+	ingestDirQueue    *IngestDirQueue
+	quarantine        *Quarantine
+	processorPools    map[ProcessorId]*ProcessorPool
+	runRequestQueues  map[ProcessorId]*RunRequestQueue
+	parkedQueues      map[ProcessorId]*RunRequestQueue // runs held while a circuit is open
+	circuitBreakers   map[ProcessorId]*CircuitBreaker
+	retiredPools      []*ProcessorPool
+	stopChan          chan struct{}
+	stopped           bool
+	mutex             *sync.RWMutex
+	logger            *log.Logger
 }
 
-func NewProcessorRegistry(definitionsPath Path) (*ProcessorRegistry, error) {
+func NewProcessorRegistry(definitionsPath Path, processorDefaults ProcessorDefaults, spoolPath Path, queueCapacity int, queueOverflow string, ingestDirQueue *IngestDirQueue, quarantine *Quarantine) (*ProcessorRegistry, error) {
 	processorPools := make(map[ProcessorId]*ProcessorPool)
+	runRequestQueues := make(map[ProcessorId]*RunRequestQueue)
+	parkedQueues := make(map[ProcessorId]*RunRequestQueue)
+	circuitBreakers := make(map[ProcessorId]*CircuitBreaker)
+	retiredPools := make([]*ProcessorPool, 0)
+	stopChan := make(chan struct{})
 	mutex := new(sync.RWMutex)
 	logger := log.New(os.Stdout, "ProcessorRegistry: ", 0)
-	registry := &ProcessorRegistry{definitionsPath, processorPools, mutex, logger}
+	registry := &ProcessorRegistry{
+		definitionsPath,
+		processorDefaults,
+		spoolPath,
+		queueCapacity,
+		queueOverflow,
+		ingestDirQueue,
+		quarantine,
+		processorPools,
+		runRequestQueues,
+		parkedQueues,
+		circuitBreakers,
+		retiredPools,
+		stopChan,
+		false,
+		mutex,
+		logger,
+	}
 
 	err := registry.BuildProcessorMap()
 
@@ -30,8 +78,31 @@ func NewProcessorRegistry(definitionsPath Path) (*ProcessorRegistry, error) {
 	return registry, nil
 }
 
-func (registry *ProcessorRegistry) Run() {
-	for range time.Tick(time.Duration(5) * time.Minute) {
+// Run reloads processors whenever processor_definitions_dir changes, and
+// every pollInterval in case a change was missed or can't be watched.
+func (registry *ProcessorRegistry) Run(pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var changes <-chan struct{}
+	watcher, err := NewDirWatcher(registry.definitionsPath, reloadDebounce)
+
+	if err != nil {
+		registry.logger.Printf("Could not watch %s, checking for changes every %s: %s", registry.definitionsPath, pollInterval, err.Error())
+	} else {
+		defer watcher.Close()
+		changes = watcher.Changes()
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-changes:
+			registry.logger.Printf("Change detected in %s", registry.definitionsPath)
+		case <-registry.stopChan:
+			return
+		}
+
 		err := registry.BuildProcessorMap()
 
 		if err != nil {
@@ -56,29 +127,119 @@ func (registry *ProcessorRegistry) BuildProcessorMap() error {
 		}
 	}
 
+	registry.mutex.RLock()
+	oldPools := make(map[ProcessorId]*ProcessorPool, len(registry.processorPools))
+
+	for processorId, processorPool := range registry.processorPools {
+		oldPools[processorId] = processorPool
+	}
+
+	registry.mutex.RUnlock()
+
 	processorPools := make(map[ProcessorId]*ProcessorPool)
+	newPools := make([]*ProcessorPool, 0)
 
 	for _, definitionDirPath := range definitionDirPaths {
-		processor, err := NewProcessor(definitionDirPath)
+		processorId := ProcessorId(definitionDirPath)
+		oldPool, found := oldPools[processorId]
+
+		// Only rebuild processors whose definition changed, so running
+		// pools aren't disturbed for nothing.
+		if found {
+			fingerprint, err := definitionFingerprint(definitionDirPath)
+
+			if err == nil && fingerprint == oldPool.processor.GetFingerprint() {
+				processorPools[processorId] = oldPool
+				continue
+			}
+		}
+
+		processor, err := NewProcessor(definitionDirPath, registry.processorDefaults)
 
 		if err != nil {
 			registry.logger.Print(err.Error())
+
+			// keep the old version running rather than dropping the
+			// processor over a half-finished edit
+			if found {
+				registry.logger.Printf("Keeping the last working definition of processor %s", processorId)
+				processorPools[processorId] = oldPool
+			}
+
 			continue
 		}
 
-		processorPool := NewProcessorPool(processor, processor.GetPoolSize())
+		runRequestQueue, err := registry.runRequestQueueFor(processor)
+
+		if err != nil {
+			registry.logger.Print(err.Error())
+			continue
+		}
+
+		breaker := registry.circuitBreakerFor(processor)
+		processorPool := NewProcessorPool(processor, processor.GetPoolSize(), runRequestQueue, registry.quarantine, breaker)
 		processorPools[processor.GetId()] = processorPool
-		registry.logger.Printf("Registered processor %s with pool_size %d", processor.GetId(), processor.GetPoolSize())
+		newPools = append(newPools, processorPool)
+
+		if found {
+			registry.logger.Printf("Reloaded processor %s with pool_size %d", processor.GetId(), processor.GetPoolSize())
+		} else {
+			registry.logger.Printf("Registered processor %s with pool_size %d", processor.GetId(), processor.GetPoolSize())
+		}
 	}
 
 	registry.mutex.Lock()
 	defer registry.mutex.Unlock()
 
-	// stop old ProcessorPool queues so they don't get more data
-	for _, processorPool := range registry.processorPools {
-		processorPool.Stop()
+	if registry.stopped {
+		// Shutdown started while the new pools were being built
+		for _, processorPool := range newPools {
+			processorPool.Halt()
+		}
+
+		return nil
+	}
+
+	// Retired pools are kept around until their running commands finish,
+	// so Shutdown can wait on them.
+	stillRunning := make([]*ProcessorPool, 0)
+
+	for _, processorPool := range registry.retiredPools {
+		if processorPool.Wait(0) {
+			continue
+		}
+
+		// a removed processor came back, so its new pool takes over
+		if _, found := processorPools[processorPool.GetProcessorId()]; found {
+			processorPool.Stop()
+		}
+
+		stillRunning = append(stillRunning, processorPool)
 	}
 
+	for processorId, processorPool := range registry.processorPools {
+		newPool, found := processorPools[processorId]
+
+		if found && newPool == processorPool {
+			continue
+		}
+
+		if found {
+			// Replaced pools finish their running commands, and leave
+			// their queue to the new pool.
+			processorPool.Stop()
+		} else {
+			// Removed processors have no one to hand their queue to,
+			// so their pools drain it before exiting.
+			registry.logger.Printf("Processor %s was removed, draining its queue", processorId)
+			processorPool.Drain()
+			go registry.releaseParked(processorId)
+		}
+
+		stillRunning = append(stillRunning, processorPool)
+	}
+
+	registry.retiredPools = stillRunning
 	registry.processorPools = processorPools
 
 	if len(processorPools) == 0 {
@@ -88,31 +249,284 @@ func (registry *ProcessorRegistry) BuildProcessorMap() error {
 	return nil
 }
 
-func (registry *ProcessorRegistry) MatchingProcessorIds(metadata Properties) []ProcessorId {
+// Shutdown stops all pools from accepting or starting RunRequests, then
+// waits up to gracePeriod for running commands to finish.  Commands still
+// running after that are sent SIGTERM, then SIGKILL.
+func (registry *ProcessorRegistry) Shutdown(gracePeriod time.Duration) {
+	registry.mutex.Lock()
+
+	if registry.stopped {
+		registry.mutex.Unlock()
+		return
+	}
+
+	registry.stopped = true
+	close(registry.stopChan)
+	processorPools := make([]*ProcessorPool, 0, len(registry.processorPools)+len(registry.retiredPools))
+	processorPools = append(processorPools, registry.retiredPools...)
+
+	for _, processorPool := range registry.processorPools {
+		processorPools = append(processorPools, processorPool)
+	}
+
+	registry.mutex.Unlock()
+
+	for _, processorPool := range processorPools {
+		processorPool.Halt()
+	}
+
+	if waitForPools(processorPools, gracePeriod) {
+		return
+	}
+
+	registry.logger.Printf("Processors still running after %s, sending SIGTERM", gracePeriod)
+
+	for _, processorPool := range processorPools {
+		processorPool.Signal(syscall.SIGTERM)
+	}
+
+	if waitForPools(processorPools, killGracePeriod) {
+		return
+	}
+
+	registry.logger.Print("Processors still running, sending SIGKILL")
+
+	for _, processorPool := range processorPools {
+		processorPool.Signal(syscall.SIGKILL)
+	}
+
+	waitForPools(processorPools, killGracePeriod)
+}
+
+func waitForPools(processorPools []*ProcessorPool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for _, processorPool := range processorPools {
+		if !processorPool.Wait(time.Until(deadline)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// runRequestQueueFor returns the queue for a processor, opening it the
+// first time the processor is seen.  Queues outlive the pools that use
+// them, so nothing queued is lost when a processor is reloaded.
+func (registry *ProcessorRegistry) runRequestQueueFor(processor Processor) (*RunRequestQueue, error) {
+	registry.mutex.RLock()
+	runRequestQueue, found := registry.runRequestQueues[processor.GetId()]
+	registry.mutex.RUnlock()
+
+	if found {
+		return runRequestQueue, nil
+	}
+
+	queuePath := registry.spoolPath.JoinPath(Path("run-" + processor.GetName()))
+	runRequestQueue, err := NewRunRequestQueue(queuePath, registry.queueCapacity, registry.ingestDirQueue)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parkedQueuePath := registry.spoolPath.JoinPath(Path("parked-" + processor.GetName()))
+	parkedQueue, err := NewRunRequestQueue(parkedQueuePath, registry.queueCapacity, registry.ingestDirQueue)
+
+	if err != nil {
+		return nil, err
+	}
+
+	registry.mutex.Lock()
+	registry.runRequestQueues[processor.GetId()] = runRequestQueue
+	registry.parkedQueues[processor.GetId()] = parkedQueue
+	registry.mutex.Unlock()
+
+	// circuits start out closed, so anything parked before a restart
+	// can run again
+	registry.releaseParked(processor.GetId())
+	return runRequestQueue, nil
+}
+
+// circuitBreakerFor returns the circuit breaker for a processor, updating
+// its settings from the processor's latest definition.
+func (registry *ProcessorRegistry) circuitBreakerFor(processor Processor) *CircuitBreaker {
+	threshold := processor.config.CircuitBreakerThreshold
+	cooldown := processor.config.CircuitBreakerCooldown
+
+	registry.mutex.Lock()
+	breaker, found := registry.circuitBreakers[processor.GetId()]
+
+	if !found {
+		processorId := processor.GetId()
+		breaker = NewCircuitBreaker(processor.GetName(), threshold, cooldown, processor.GetFingerprint(), func() {
+			registry.releaseParked(processorId)
+		})
+		registry.circuitBreakers[processorId] = breaker
+	}
+
+	registry.mutex.Unlock()
+
+	if found {
+		breaker.Configure(threshold, cooldown, processor.GetFingerprint())
+	}
+
+	return breaker
+}
+
+// releaseParked moves every run that was held while a processor's circuit
+// was open back into the processor's queue.
+func (registry *ProcessorRegistry) releaseParked(processorId ProcessorId) {
+	registry.mutex.RLock()
+	parkedQueue, found := registry.parkedQueues[processorId]
+	runRequestQueue := registry.runRequestQueues[processorId]
+	registry.mutex.RUnlock()
+
+	if !found {
+		return
+	}
+
+	released := 0
+
+	for {
+		entry, ok := parkedQueue.Spool.TryTake()
+
+		if !ok {
+			break
+		}
+
+		err := runRequestQueue.Spool.Put(entry.Properties)
+
+		if err != nil {
+			registry.logger.Printf("Could not release parked run: %s", err.Error())
+			parkedQueue.Release(entry)
+			break
+		}
+
+		parkedQueue.Ack(entry)
+		released++
+	}
+
+	if released > 0 {
+		registry.logger.Printf("Released %d parked runs for processor %s", released, processorId)
+	}
+}
+
+// QueuedInputDirs returns the input directories of every run that is
+// queued or in progress.
+func (registry *ProcessorRegistry) QueuedInputDirs() map[Path]bool {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	dirs := make(map[Path]bool)
+
+	for _, queues := range []map[ProcessorId]*RunRequestQueue{registry.runRequestQueues, registry.parkedQueues} {
+		for _, runRequestQueue := range queues {
+			for dir := range runRequestQueue.QueuedDirs() {
+				dirs[dir] = true
+			}
+		}
+	}
+
+	return dirs
+}
+
+// Saturated returns true if any registered processor's queue is full.
+// Queues never count as full when runs that don't fit are rejected.
+func (registry *ProcessorRegistry) Saturated() bool {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	if registry.queueOverflow == OverflowReject {
+		return false
+	}
+
+	for processorId, processorPool := range registry.processorPools {
+		// a pool without workers never drains, so don't wait on it
+		if processorPool.processor.GetPoolSize() == 0 {
+			continue
+		}
+
+		if runRequestQueue, found := registry.runRequestQueues[processorId]; found && runRequestQueue.Full() {
+			return true
+		}
+
+		if parkedQueue, found := registry.parkedQueues[processorId]; found && parkedQueue.Full() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchingHooks returns the processors with a hook that matches metadata,
+// or the sample of the data, along with the name of the hook that matched.
+// The sampler is shared, so the data is read at most once.
+func (registry *ProcessorRegistry) MatchingHooks(metadata Properties, sampler *DataSampler) map[ProcessorId]string {
 	registry.mutex.RLock()
 	defer registry.mutex.RUnlock()
 
-	matchingProcessorIds := make([]ProcessorId, 0)
+	matchingHooks := make(map[ProcessorId]string)
 
 	for _, processorPool := range registry.processorPools {
-		if processorPool.ProcessorHookMatches(metadata) {
-			matchingProcessorIds = append(matchingProcessorIds, processorPool.GetProcessorId())
+		if hookName, matched := processorPool.ProcessorMatchingHook(metadata, sampler); matched {
+			matchingHooks[processorPool.GetProcessorId()] = hookName
 		}
 	}
 
-	return matchingProcessorIds
+	return matchingHooks
 }
 
 func (registry *ProcessorRegistry) SendToProcessorId(processorId ProcessorId, runRequest RunRequest) error {
 	registry.mutex.RLock()
-	defer registry.mutex.RUnlock()
+	processorPool, found := registry.processorPools[processorId]
+	breaker := registry.circuitBreakers[processorId]
+	runRequestQueue := registry.runRequestQueues[processorId]
+	parkedQueue := registry.parkedQueues[processorId]
+	registry.mutex.RUnlock()
+
+	if !found {
+		return fmt.Errorf("Could not send RunRequest to ProcessorId %s", processorId)
+	}
+
+	if registry.queueOverflow == OverflowReject && (runRequestQueue.Full() || parkedQueue.Full()) {
+		return registry.reject(processorPool.GetProcessorName(), runRequest)
+	}
+
+	if breaker.Parking() {
+		err := parkedQueue.Put(runRequest)
+
+		if err != nil {
+			return err
+		}
+
+		registry.logger.Printf("Parked %s while the circuit for processor %s is open", runRequest.InputPath, processorId)
+
+		// the circuit may have gone half-open while this was being parked
+		if !breaker.Parking() {
+			registry.releaseParked(processorId)
+		}
 
-	if processorPool, found := registry.processorPools[processorId]; found {
-		processorPool.Run(runRequest)
 		return nil
 	}
 
-	return fmt.Errorf("Could not send RunRequest to ProcessorId %s", processorId)
+	// The queue is shared by every version of the processor, so this
+	// works even if a reload has stopped the pool that was looked up.
+	return runRequestQueue.Put(runRequest)
+}
+
+// reject moves a run whose processor's queue is full to the quarantine,
+// so it can be replayed once the processor catches up.
+func (registry *ProcessorRegistry) reject(processorName string, runRequest RunRequest) error {
+	reason := fmt.Sprintf("Queue for processor %s is full", processorName)
+	report := FailureReport{processorName, -1, time.Time{}, time.Time{}, runRequest.Attempt, reason}
+	failedPath, err := registry.quarantine.Add(runRequest.InputPath, report)
+
+	if err != nil {
+		return fmt.Errorf("Could not reject %s: %s", runRequest.InputPath, err.Error())
+	}
+
+	registry.logger.Printf("%s, so rejected %s to %s", reason, runRequest.InputPath, failedPath)
+	return nil
 }
 
 func (registry *ProcessorRegistry) ProcessorNameForId(processorId ProcessorId) (string, error) {
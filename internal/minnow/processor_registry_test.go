@@ -0,0 +1,260 @@
+package minnow
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func makeTestRegistry(t *testing.T, definitionsPath, workPath Path) *ProcessorRegistry {
+	spoolPath := workPath.JoinPath("spool")
+	ingestDirQueue, err := NewIngestDirQueue(spoolPath.JoinPath("ingest"), 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	quarantine, err := NewQuarantine(workPath.JoinPath("failed"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	registry, err := NewProcessorRegistry(definitionsPath, ProcessorDefaults{}, spoolPath, 0, OverflowBlock, ingestDirQueue, quarantine)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return registry
+}
+
+func TestProcessorRegistryIncrementalReload(t *testing.T) {
+	definitionsPath := Path("/tmp/minnow-definitions-" + randomString(20))
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	definitionsPath.Mkdir()
+	workPath.Mkdir()
+	defer definitionsPath.RmdirRecursive()
+	defer workPath.RmdirRecursive()
+
+	unchangedPath := makeTestProcessorDefinition(t, "pool_size = 1", "true")
+	changedPath := makeTestProcessorDefinition(t, "pool_size = 1", "true")
+	unchangedPath.Rename(definitionsPath.JoinPath("unchanged"))
+	changedPath.Rename(definitionsPath.JoinPath("changed"))
+	unchangedId := ProcessorId(definitionsPath.JoinPath("unchanged"))
+	changedId := ProcessorId(definitionsPath.JoinPath("changed"))
+
+	registry := makeTestRegistry(t, definitionsPath, workPath)
+	defer registry.Shutdown(0)
+
+	unchangedPool := registry.processorPools[unchangedId]
+	changedPool := registry.processorPools[changedId]
+	definitionsPath.JoinPath("changed", "start.sh").WriteBytes([]byte("#!/bin/sh\nexit 0\n"))
+
+	err := registry.BuildProcessorMap()
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if registry.processorPools[unchangedId] != unchangedPool {
+		t.Errorf("Pool for an unchanged processor should not be replaced")
+	}
+
+	if registry.processorPools[changedId] == changedPool {
+		t.Errorf("Pool for a changed processor should be replaced")
+	}
+
+	if !changedPool.Wait(time.Duration(5) * time.Second) {
+		t.Errorf("Replaced pool should exit")
+	}
+
+	definitionsPath.JoinPath("unchanged").RmdirRecursive()
+	err = registry.BuildProcessorMap()
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if _, found := registry.processorPools[unchangedId]; found {
+		t.Errorf("Removed processor should be unregistered")
+	}
+
+	if !unchangedPool.Wait(time.Duration(5) * time.Second) {
+		t.Errorf("Removed processor's pool should exit once its queue is drained")
+	}
+}
+
+func TestProcessorPoolDrain(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "", "touch $2/ran")
+	defer definitionPath.RmdirRecursive()
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	ingestDirQueue, err := NewIngestDirQueue(definitionPath.JoinPath("ingest"), 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	queue, err := NewRunRequestQueue(definitionPath.JoinPath("queue"), 0, ingestDirQueue)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	inputPath := definitionPath.JoinPath("input")
+	outputPath := definitionPath.JoinPath("output")
+	inputPath.Mkdir()
+	outputPath.Mkdir()
+	queue.Put(RunRequest{inputPath, outputPath, make([]ProcessorId, 0), nil, 0, time.Time{}})
+
+	breaker := NewCircuitBreaker(processor.GetName(), 0, 0, processor.GetFingerprint(), nil)
+	pool := NewProcessorPool(processor, 1, queue, nil, breaker)
+	pool.Drain()
+
+	if !pool.Wait(time.Duration(5) * time.Second) {
+		t.Fatalf("Draining pool should exit once its queue is empty")
+	}
+
+	if queue.Len() != 0 || !outputPath.JoinPath("ran").Exists() {
+		t.Errorf("Draining pool should run what was left in its queue")
+	}
+}
+
+func TestProcessorRegistryQueueOverflow(t *testing.T) {
+	// how many runs end up queued and quarantined when two are sent to a
+	// queue that holds one
+	tests := map[string][2]int{
+		OverflowBlock:  {2, 0},
+		OverflowReject: {1, 1},
+	}
+
+	for queueOverflow, expected := range tests {
+		workPath := Path("/tmp/minnow-work-" + randomString(20))
+		workPath.Mkdir()
+		defer workPath.RmdirRecursive()
+
+		// without workers, runs stay queued so they can be counted
+		definitionsPath := workPath.JoinPath("processors")
+		definitionsPath.Mkdir()
+		makeTestProcessorDefinition(t, "pool_size = 0", "true").Rename(definitionsPath.JoinPath("test"))
+		ingestDirQueue, err := NewIngestDirQueue(workPath.JoinPath("spool", "ingest"), 0)
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		quarantine, err := NewQuarantine(workPath.JoinPath("failed"))
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		registry, err := NewProcessorRegistry(definitionsPath, ProcessorDefaults{}, workPath.JoinPath("spool"), 1, queueOverflow, ingestDirQueue, quarantine)
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		defer registry.Shutdown(0)
+
+		var processorId ProcessorId
+		var runRequestQueue *RunRequestQueue
+
+		for id, queue := range registry.runRequestQueues {
+			processorId, runRequestQueue = id, queue
+		}
+
+		for _, name := range []string{"first", "second"} {
+			inputPath := workPath.JoinPath(Path(name + "-input"))
+			outputPath := workPath.JoinPath(Path(name + "-output"))
+			inputPath.Mkdir()
+			outputPath.Mkdir()
+			WriteWorkState(inputPath, WorkState{WorkKindRun, RunStatusQueued, processorId, make([]ProcessorId, 0), inputPath, outputPath, 0, make([]Path, 0), 0, ""})
+			err = registry.SendToProcessorId(processorId, RunRequest{inputPath, outputPath, make([]ProcessorId, 0), ingestDirQueue, 0, time.Time{}})
+
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+
+		failedPaths, err := quarantine.Path().Glob("*")
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		if runRequestQueue.Len() != expected[0] || len(failedPaths) != expected[1] {
+			t.Errorf("With %s, expected %d queued and %d rejected, got %d and %d", queueOverflow, expected[0], expected[1], runRequestQueue.Len(), len(failedPaths))
+		}
+
+		if len(failedPaths) == 1 && !failedPaths[0].JoinPath("input").IsDir() {
+			t.Errorf("Rejected run's input should be in the quarantine")
+		}
+	}
+}
+
+func TestProcessorRegistrySendDuringReload(t *testing.T) {
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	workPath.Mkdir()
+	defer workPath.RmdirRecursive()
+
+	// without workers, every run that was sent stays queued
+	definitionsPath := workPath.JoinPath("processors")
+	definitionsPath.Mkdir()
+	definitionPath := definitionsPath.JoinPath("test")
+	makeTestProcessorDefinition(t, "pool_size = 0", "true").Rename(definitionPath)
+	registry := makeTestRegistry(t, definitionsPath, workPath)
+	defer registry.Shutdown(0)
+
+	var processorId ProcessorId
+	var runRequestQueue *RunRequestQueue
+
+	for id, queue := range registry.runRequestQueues {
+		processorId, runRequestQueue = id, queue
+	}
+
+	// a send can look up a pool just before a reload stops it
+	registry.processorPools[processorId].Stop()
+	err := registry.SendToProcessorId(processorId, RunRequest{workPath.JoinPath("input"), workPath.JoinPath("output"), make([]ProcessorId, 0), registry.ingestDirQueue, 0, time.Time{}})
+
+	if err != nil {
+		t.Fatalf("Sending to a stopped pool failed: %s", err.Error())
+	}
+
+	// changing the definition's fingerprint replaces the pool each time
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 50; i++ {
+			definitionPath.JoinPath(Path("reload-" + strconv.Itoa(i))).WriteBytes([]byte("reload"))
+			registry.BuildProcessorMap()
+		}
+	}()
+
+	sent := 1
+
+	for i := 0; i < 200; i++ {
+		inputPath := workPath.JoinPath(Path("input-" + strconv.Itoa(i)))
+		err := registry.SendToProcessorId(processorId, RunRequest{inputPath, workPath.JoinPath("output"), make([]ProcessorId, 0), registry.ingestDirQueue, 0, time.Time{}})
+
+		if err != nil {
+			t.Errorf("Sending during a reload failed: %s", err.Error())
+			continue
+		}
+
+		sent++
+	}
+
+	<-done
+
+	if runRequestQueue.Len() != sent {
+		t.Errorf("Expected %d runs queued, found %d", sent, runRequestQueue.Len())
+	}
+}
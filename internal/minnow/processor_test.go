@@ -0,0 +1,394 @@
+package minnow
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeTestProcessorDefinition(t *testing.T, config, script string) Path {
+	definitionPath := Path("/tmp/minnow-processor-" + randomString(20))
+	err := definitionPath.Mkdir()
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	files := map[string]string{
+		"config.properties": "start_script = start.sh\nhook_file = hook.properties\n" + config,
+		"hook.properties":   "type = test",
+		"start.sh":          "#!/bin/sh\n" + script,
+	}
+
+	for name, contents := range files {
+		err = definitionPath.JoinPath(Path(name)).WriteBytes([]byte(contents))
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	err = os.Chmod(string(definitionPath.JoinPath("start.sh")), 0700)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	return definitionPath
+}
+
+func TestProcessorTimeoutDefault(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "", "true")
+	defer definitionPath.RmdirRecursive()
+
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{Timeout: time.Duration(10) * time.Second})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if processor.config.Timeout != time.Duration(10)*time.Second {
+		t.Errorf("Expected the default timeout, got %s", processor.config.Timeout)
+	}
+}
+
+func TestProcessorTimeoutKillsProcessGroup(t *testing.T) {
+	// the background sleep would keep the command's output open
+	// if only the start script were killed
+	definitionPath := makeTestProcessorDefinition(t, "timeout = 1", "sleep 30 &\nsleep 30")
+	defer definitionPath.RmdirRecursive()
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	inputPath := definitionPath.JoinPath("input")
+	outputPath := definitionPath.JoinPath("output")
+	inputPath.Mkdir()
+	outputPath.Mkdir()
+
+	started := time.Now()
+	err = processor.RunCommand(RunRequest{inputPath, outputPath, make([]ProcessorId, 0), nil, 0, time.Time{}})
+
+	if err == nil {
+		t.Errorf("Expected the run to fail after timing out")
+	}
+
+	if time.Since(started) > time.Duration(10)*time.Second {
+		t.Errorf("Run took %s, so the process group was not killed", time.Since(started))
+	}
+
+	output, err := outputPath.JoinPath(Path("_" + processor.GetName() + "_output.txt")).ReadBytes()
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if !strings.Contains(string(output), "timeout") {
+		t.Errorf("Timeout not recorded in output: %s", output)
+	}
+}
+
+func TestProcessorRetryBackoff(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "max_retries = 5\nretry_backoff = 10\nretry_max_backoff = 30", "true")
+	defer definitionPath.RmdirRecursive()
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := []int{10, 20, 30, 30}
+
+	for i, seconds := range expected {
+		backoff := processor.RetryBackoff(i + 1)
+
+		if backoff != time.Duration(seconds)*time.Second {
+			t.Errorf("Expected backoff of %ds for attempt %d, got %s", seconds, i+1, backoff)
+		}
+	}
+}
+
+func TestProcessorPrepareRetry(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "max_retries = 1\nretry_backoff = 1", "false")
+	defer definitionPath.RmdirRecursive()
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	inputPath := definitionPath.JoinPath("input")
+	outputPath := definitionPath.JoinPath("output")
+	inputPath.Mkdir()
+	outputPath.Mkdir()
+	state := WorkState{WorkKindRun, RunStatusRunning, processor.GetId(), make([]ProcessorId, 0), inputPath, outputPath, 0, make([]Path, 0), 0, ""}
+	WriteWorkState(inputPath, state)
+	defer RemoveWorkState(inputPath)
+
+	runRequest := RunRequest{inputPath, outputPath, make([]ProcessorId, 0), nil, 0, time.Time{}}
+	retryRequest, retry, err := processor.PrepareRetry(runRequest, FailureGeneric)
+
+	if err != nil || !retry {
+		t.Fatalf("Expected a retry: %v", err)
+	}
+
+	if retryRequest.OutputPath == outputPath || !retryRequest.OutputPath.IsDir() {
+		t.Errorf("Retry should get a fresh output directory, got %s", retryRequest.OutputPath)
+	}
+
+	if retryRequest.Attempt != 1 || !retryRequest.NotBefore.After(time.Now()) {
+		t.Errorf("Retry has the wrong attempt or time: %d %s", retryRequest.Attempt, retryRequest.NotBefore)
+	}
+
+	state, err = ReadWorkState(inputPath)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(state.FailedOutputPaths) != 1 || state.FailedOutputPaths[0] != outputPath || state.OutputPath != retryRequest.OutputPath {
+		t.Errorf("Run state not updated for retry: %v", state)
+	}
+
+	_, retry, _ = processor.PrepareRetry(retryRequest, FailureGeneric)
+
+	if retry {
+		t.Errorf("Should be out of retries")
+	}
+}
+
+func TestProcessorPrepareTransientRetry(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "max_transient_retries = 1\nretry_backoff = 1", "exit 75")
+	defer definitionPath.RmdirRecursive()
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	inputPath := definitionPath.JoinPath("input")
+	outputPath := definitionPath.JoinPath("output")
+	inputPath.Mkdir()
+	outputPath.Mkdir()
+	state := WorkState{WorkKindRun, RunStatusRunning, processor.GetId(), make([]ProcessorId, 0), inputPath, outputPath, 0, make([]Path, 0), 0, ""}
+	WriteWorkState(inputPath, state)
+	defer RemoveWorkState(inputPath)
+
+	runRequest := RunRequest{inputPath, outputPath, make([]ProcessorId, 0), nil, 0, time.Time{}}
+	retryRequest, retry, err := processor.PrepareRetry(runRequest, FailureTransient)
+
+	if err != nil || !retry {
+		t.Fatalf("Expected a retry: %v", err)
+	}
+
+	// the failed try's output is kept, and it isn't counted as an attempt
+	if retryRequest.OutputPath == outputPath || !retryRequest.OutputPath.IsDir() || retryRequest.Attempt != 0 {
+		t.Errorf("Retry should get a fresh output directory and the same attempt, got %s %d", retryRequest.OutputPath, retryRequest.Attempt)
+	}
+
+	state, err = ReadWorkState(inputPath)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(state.FailedOutputPaths) != 1 || state.FailedOutputPaths[0] != outputPath || state.TransientRetries != 1 {
+		t.Errorf("Run state not updated for retry: %v", state)
+	}
+
+	_, retry, _ = processor.PrepareRetry(retryRequest, FailureTransient)
+
+	if retry {
+		t.Errorf("Should be out of transient retries")
+	}
+}
+
+func TestProcessorExitCodes(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "skip_exit_codes = 3, 4\nfail_exit_codes = 9", "exit $1")
+	defer definitionPath.RmdirRecursive()
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := map[int]string{
+		1:  FailureGeneric,
+		9:  FailurePermanent,
+		65: FailureGeneric, // overridden by fail_exit_codes
+		75: FailureTransient,
+	}
+
+	for code, kind := range expected {
+		runError := processor.classifyFailure(exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run())
+
+		if runError == nil || runError.Kind != kind || runError.ExitCode != code {
+			t.Errorf("Expected a %s failure for exit code %d, got %v", kind, code, runError)
+		}
+	}
+
+	for _, code := range []int{3, 4} {
+		if runError := processor.classifyFailure(exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()); runError != nil {
+			t.Errorf("Expected exit code %d to be skipped, got %v", code, runError)
+		}
+	}
+}
+
+func TestProcessorExitCodesOverlap(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "retry_exit_codes = 3", "true")
+	defer definitionPath.RmdirRecursive()
+	_, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err == nil {
+		t.Errorf("Expected an error when an exit code is used for both skip and retry")
+	}
+}
+
+func TestProcessorSkipRemovesRun(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "", "exit 3")
+	defer definitionPath.RmdirRecursive()
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	inputPath := definitionPath.JoinPath("input")
+	outputPath := definitionPath.JoinPath("output")
+	inputPath.Mkdir()
+	outputPath.Mkdir()
+	state := WorkState{WorkKindRun, RunStatusQueued, processor.GetId(), make([]ProcessorId, 0), inputPath, outputPath, 0, make([]Path, 0), 0, ""}
+	WriteWorkState(inputPath, state)
+
+	err = processor.RunCommand(RunRequest{inputPath, outputPath, make([]ProcessorId, 0), nil, 0, time.Time{}})
+
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if inputPath.Exists() || outputPath.Exists() || StatePathFor(inputPath).Exists() {
+		t.Errorf("Skipped run was not cleaned up")
+	}
+}
+
+func TestProcessorHookList(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "hook_file = hook.properties, other.properties", "true")
+	defer definitionPath.RmdirRecursive()
+	definitionPath.JoinPath("other.properties").WriteBytes([]byte("type = other"))
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tests := map[string]string{"test": "hook.properties", "other": "other.properties", "neither": ""}
+
+	for value, expected := range tests {
+		hookName, matched := processor.MatchingHook(Properties{"type": value}, nil)
+
+		if hookName != expected || matched != (len(expected) > 0) {
+			t.Errorf("type = %s matched %q, expected %q", value, hookName, expected)
+		}
+	}
+}
+
+func TestProcessorHooksDir(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "", "true")
+	defer definitionPath.RmdirRecursive()
+	hooksPath := definitionPath.JoinPath(HooksDirName)
+	hooksPath.Mkdir()
+
+	files := map[Path]string{
+		definitionPath.JoinPath("config.properties"): "start_script = start.sh\nhook_type = globmatchhook",
+		hooksPath.JoinPath("csv.properties"):         "filename = *.csv",
+		hooksPath.JoinPath("tsv.properties"):         "filename = *.tsv",
+		hooksPath.JoinPath(".csv.properties.swp"):    "not a hook",
+	}
+
+	for path, contents := range files {
+		err := path.WriteBytes([]byte(contents))
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(processor.config.Hooks) != 2 {
+		t.Fatalf("Expected 2 hooks, found %d", len(processor.config.Hooks))
+	}
+
+	hookName, matched := processor.MatchingHook(Properties{"filename": "readings.tsv"}, nil)
+
+	if !matched || hookName != "hooks/tsv.properties" {
+		t.Errorf("Expected hooks/tsv.properties to match, got %q", hookName)
+	}
+}
+
+func TestProcessorMixedHookTypes(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "", "true")
+	defer definitionPath.RmdirRecursive()
+	hooksPath := definitionPath.JoinPath(HooksDirName)
+	hooksPath.Mkdir()
+
+	files := map[Path]string{
+		definitionPath.JoinPath("config.properties"): "start_script = start.sh\nhook_type = expressionhook",
+		hooksPath.JoinPath("blueprints"):             "type = blueprints or type = drawings",
+		hooksPath.JoinPath("pdf.properties"):         "hook_type = contentmatchhook\nextension = pdf",
+		hooksPath.JoinPath("csv.properties"):         "hook_type = globmatchhook\nfilename = *.csv",
+		definitionPath.JoinPath("scan.pdf"):          "%PDF-1.5",
+	}
+
+	for path, contents := range files {
+		err := path.WriteBytes([]byte(contents))
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	processor, err := NewProcessor(definitionPath, ProcessorDefaults{})
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	tests := []struct {
+		metadata Properties
+		dataName string
+		expected string
+	}{
+		{Properties{"type": "drawings"}, "notes.txt", "hooks/blueprints"},
+		{Properties{"filename": "readings.csv"}, "notes.txt", "hooks/csv.properties"},
+		{Properties{"type": "photos"}, "scan.pdf", "hooks/pdf.properties"},
+		{Properties{"type": "photos"}, "notes.txt", ""},
+	}
+
+	for _, test := range tests {
+		hookName, matched := processor.MatchingHook(test.metadata, NewDataSampler(definitionPath.JoinPath(Path(test.dataName))))
+
+		if hookName != test.expected || matched != (len(test.expected) > 0) {
+			t.Errorf("%v with %s matched %q, expected %q", test.metadata, test.dataName, hookName, test.expected)
+		}
+	}
+}
+
+func TestProcessorWithoutHooks(t *testing.T) {
+	definitionPath := makeTestProcessorDefinition(t, "", "true")
+	defer definitionPath.RmdirRecursive()
+	definitionPath.JoinPath("config.properties").WriteBytes([]byte("start_script = start.sh"))
+
+	if _, err := NewProcessor(definitionPath, ProcessorDefaults{}); err == nil {
+		t.Errorf("Processor without hooks should not have loaded")
+	}
+}
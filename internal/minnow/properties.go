@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -46,6 +47,23 @@ func BytesToProperties(input []byte) (Properties, error) {
 	return properties, nil
 }
 
+func (properties Properties) ToBytes() []byte {
+	keys := make([]string, 0, len(properties))
+
+	for key := range properties {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys) // keep the output stable
+	var buffer bytes.Buffer
+
+	for _, key := range keys {
+		buffer.WriteString(fmt.Sprintf("%s = %s\n", key, properties[key]))
+	}
+
+	return buffer.Bytes()
+}
+
 func PropertiesFromFile(path Path) (Properties, error) {
 	propertiesBytes, err := path.ReadBytes()
 
@@ -0,0 +1,179 @@
+package minnow
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	FailedDirName     = "failed"
+	FailureReportName = "failure.properties"
+
+	// name given to pairs rejected at ingest, in place of a processor name
+	rejectedIngestName = "ingest"
+)
+
+// FailureReport is written alongside a quarantined run so operators can see
+// why it failed without digging through the logs.
+type FailureReport struct {
+	ProcessorName string
+	ExitCode      int // -1 if the command didn't exit normally
+	Started       time.Time
+	Finished      time.Time
+	Attempts      int
+	Reason        string
+}
+
+func (report FailureReport) ToProperties(state WorkState) Properties {
+	properties := Properties{
+		"processor":    report.ProcessorName,
+		"processor_id": string(state.ProcessorId),
+		"exit_code":    strconv.Itoa(report.ExitCode),
+		"attempts":     strconv.Itoa(report.Attempts),
+		"processed_by": joinProcessorIds(state.ProcessedBy),
+		"input_dir":    string(state.InputPath),
+		"reason":       report.Reason,
+	}
+
+	if !report.Started.IsZero() {
+		properties["started"] = report.Started.UTC().Format(timestampFormat)
+	}
+
+	if !report.Finished.IsZero() {
+		properties["finished"] = report.Finished.UTC().Format(timestampFormat)
+	}
+
+	return properties
+}
+
+// Quarantine holds failed runs outside of the work path, one directory
+// per run, so they can be triaged in one place.
+type Quarantine struct {
+	path   Path
+	logger *log.Logger
+}
+
+func NewQuarantine(path Path) (*Quarantine, error) {
+	if !path.Exists() {
+		err := os.MkdirAll(string(path), 0700)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger := log.New(os.Stdout, "Quarantine: ", 0)
+	return &Quarantine{path, logger}, nil
+}
+
+func (quarantine *Quarantine) Path() Path {
+	return quarantine.path
+}
+
+// Add moves the failed run whose input directory is inputPath into the
+// quarantine, along with the output directories of each of its attempts
+// and a failure report.  The run's work state is removed once everything
+// has been moved.  If an earlier try was cut short, this one finishes
+// moving the run into the same directory.
+func (quarantine *Quarantine) Add(inputPath Path, report FailureReport) (Path, error) {
+	state, err := ReadWorkState(inputPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	failedPath := state.QuarantinePath
+
+	if len(failedPath) == 0 || !failedPath.IsDir() {
+		failedPath, err = makeRandomPath(quarantine.path, report.ProcessorName)
+
+		if err != nil {
+			return "", err
+		}
+
+		state.QuarantinePath = failedPath
+		err = WriteWorkState(inputPath, state)
+
+		if err != nil {
+			failedPath.Rmdir()
+			return "", err
+		}
+	}
+
+	err = failedPath.JoinPath(Path(FailureReportName)).WriteBytes(report.ToProperties(state).ToBytes())
+
+	if err != nil {
+		return failedPath, err
+	}
+
+	moves := map[Path]Path{
+		state.InputPath:  failedPath.JoinPath("input"),
+		state.OutputPath: failedPath.JoinPath("output"),
+	}
+
+	for i, failedOutputPath := range state.FailedOutputPaths {
+		moves[failedOutputPath] = failedPath.JoinPath(Path(fmt.Sprintf("output-attempt-%d", i+1)))
+	}
+
+	for source, destination := range moves {
+		if !source.Exists() {
+			// already moved by an earlier try
+			continue
+		}
+
+		err = source.Move(destination)
+
+		if err != nil {
+			return failedPath, err
+		}
+	}
+
+	err = RemoveWorkState(inputPath)
+
+	if err != nil {
+		return failedPath, err
+	}
+
+	quarantine.logger.Printf("Moved failed run %s to %s", inputPath, failedPath)
+	return failedPath, nil
+}
+
+// AddRejected moves a data/metadata pair that was rejected at ingest into
+// the quarantine, laid out like a failed run so it can be replayed once
+// the problem is fixed.
+func (quarantine *Quarantine) AddRejected(metadataPath, dataPath Path, reason string) (Path, error) {
+	failedPath, err := makeRandomPath(quarantine.path, rejectedIngestName)
+
+	if err != nil {
+		return "", err
+	}
+
+	report := FailureReport{rejectedIngestName, -1, time.Time{}, time.Time{}, 0, reason}
+	state := WorkState{InputPath: metadataPath.Parent()}
+	err = failedPath.JoinPath(Path(FailureReportName)).WriteBytes(report.ToProperties(state).ToBytes())
+
+	if err != nil {
+		return failedPath, err
+	}
+
+	inputPath := failedPath.JoinPath("input")
+	err = inputPath.Mkdir()
+
+	if err != nil {
+		return failedPath, err
+	}
+
+	for _, path := range []Path{metadataPath, dataPath} {
+		err = path.Move(inputPath.JoinPath(Path(path.Name())))
+
+		if err != nil {
+			return failedPath, err
+		}
+	}
+
+	quarantine.logger.Printf("Moved rejected %s to %s", dataPath, failedPath)
+	return failedPath, nil
+}
@@ -0,0 +1,128 @@
+package minnow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuarantineAdd(t *testing.T) {
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	workPath.Mkdir()
+	defer workPath.RmdirRecursive()
+
+	quarantine, err := NewQuarantine(workPath.JoinPath("failed"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	inputPath := workPath.JoinPath("test-input")
+	outputPath := workPath.JoinPath("test-output")
+	failedOutputPath := workPath.JoinPath("test-output-1")
+
+	for _, dir := range []Path{inputPath, outputPath, failedOutputPath} {
+		dir.Mkdir()
+	}
+
+	inputPath.JoinPath("data.properties").WriteBytes([]byte("type = test"))
+	inputPath.JoinPath("data").WriteBytes([]byte("data"))
+	processedBy := []ProcessorId{"/processors/a"}
+	state := WorkState{WorkKindRun, RunStatusFailed, "/processors/test", processedBy, inputPath, outputPath, 1, []Path{failedOutputPath}, 0, ""}
+	WriteWorkState(inputPath, state)
+
+	started := time.Now()
+	report := FailureReport{"test", 65, started, started.Add(time.Second), 2, "permanent failure: exit status 65"}
+	failedPath, err := quarantine.Add(inputPath, report)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, name := range []Path{"input/data", "input/data.properties", "output", "output-attempt-1"} {
+		if !failedPath.JoinPath(name).Exists() {
+			t.Errorf("%s missing from quarantine", name)
+		}
+	}
+
+	if inputPath.Exists() || outputPath.Exists() || failedOutputPath.Exists() || StatePathFor(inputPath).Exists() {
+		t.Errorf("Failed run left behind in work path")
+	}
+
+	properties, err := PropertiesFromFile(failedPath.JoinPath(FailureReportName))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := Properties{
+		"processor":    "test",
+		"processor_id": "/processors/test",
+		"exit_code":    "65",
+		"attempts":     "2",
+		"processed_by": "/processors/a",
+		"started":      started.UTC().Format(timestampFormat),
+	}
+
+	for key, value := range expected {
+		if properties[key] != value {
+			t.Errorf("Expected %s = %s in failure report, got %s", key, value, properties[key])
+		}
+	}
+}
+
+func TestQuarantineAddResumes(t *testing.T) {
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	workPath.Mkdir()
+	defer workPath.RmdirRecursive()
+
+	quarantine, err := NewQuarantine(workPath.JoinPath("failed"))
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	inputPath := workPath.JoinPath("test-input")
+	outputPath := workPath.JoinPath("test-output")
+	inputPath.Mkdir()
+	outputPath.Mkdir()
+	inputPath.JoinPath("data").WriteBytes([]byte("data"))
+	outputPath.JoinPath("_test_output.txt").WriteBytes([]byte("failed"))
+
+	// an earlier try picked a directory and moved the output, then died
+	failedPath, err := makeRandomPath(quarantine.Path(), "test")
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	outputPath.Move(failedPath.JoinPath("output"))
+	state := WorkState{WorkKindRun, RunStatusFailed, "/processors/test", make([]ProcessorId, 0), inputPath, outputPath, 0, make([]Path, 0), 0, failedPath}
+	WriteWorkState(inputPath, state)
+
+	report := FailureReport{"test", 1, time.Time{}, time.Time{}, 1, "failure: exit status 1"}
+	resumedPath, err := quarantine.Add(inputPath, report)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	failedPaths, err := quarantine.Path().Glob("*")
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if resumedPath != failedPath || len(failedPaths) != 1 {
+		t.Errorf("Run should have been moved into %s, found %v", failedPath, failedPaths)
+	}
+
+	for _, name := range []Path{"input/data", "output/_test_output.txt", FailureReportName} {
+		if !failedPath.JoinPath(name).Exists() {
+			t.Errorf("%s missing from quarantine", name)
+		}
+	}
+
+	if inputPath.Exists() || StatePathFor(inputPath).Exists() {
+		t.Errorf("Failed run left behind in work path")
+	}
+}
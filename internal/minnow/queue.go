@@ -0,0 +1,284 @@
+package minnow
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The queues below wrap a Spool, converting each kind of work to and from
+// the Properties stored in its entries.
+
+func (info DispatchInfo) ToProperties() Properties {
+	properties := Properties{
+		"metadata_path": string(info.MetadataPath),
+		"data_path":     string(info.DataPath),
+		"processed_by":  joinProcessorIds(info.ProcessedBy),
+	}
+
+	if len(info.ProcessorId) > 0 {
+		properties["processor_id"] = string(info.ProcessorId)
+	}
+
+	return properties
+}
+
+func DispatchInfoFromProperties(properties Properties) (DispatchInfo, error) {
+	for _, key := range []string{"metadata_path", "data_path"} {
+		if _, found := properties[key]; !found {
+			return DispatchInfo{}, fmt.Errorf("Dispatch entry missing %s property", key)
+		}
+	}
+
+	metadataPath := Path(properties["metadata_path"])
+	dataPath := Path(properties["data_path"])
+	processedBy := splitProcessorIds(properties["processed_by"])
+	return DispatchInfo{metadataPath, dataPath, processedBy, ProcessorId(properties["processor_id"])}, nil
+}
+
+func (info IngestDirInfo) ToProperties() Properties {
+	properties := Properties{
+		"ingest_dir":           string(info.IngestPath),
+		"min_age":              info.MinAge.String(),
+		"processed_by":         joinProcessorIds(info.ProcessedBy),
+		"remove_once_ingested": strconv.FormatBool(info.RemoveOnceIngested),
+	}
+
+	if len(info.MetadataName) > 0 {
+		properties["metadata_name"] = info.MetadataName
+	}
+
+	return properties
+}
+
+func IngestDirInfoFromProperties(properties Properties) (IngestDirInfo, error) {
+	ingestPath, found := properties["ingest_dir"]
+
+	if !found {
+		return IngestDirInfo{}, fmt.Errorf("Ingest entry missing ingest_dir property")
+	}
+
+	minAge, err := time.ParseDuration(properties["min_age"])
+
+	if err != nil {
+		return IngestDirInfo{}, fmt.Errorf("Invalid min_age in ingest entry: %s", err.Error())
+	}
+
+	removeOnceIngested, err := strconv.ParseBool(properties["remove_once_ingested"])
+
+	if err != nil {
+		return IngestDirInfo{}, fmt.Errorf("Invalid remove_once_ingested in ingest entry: %s", err.Error())
+	}
+
+	return IngestDirInfo{Path(ingestPath), minAge, splitProcessorIds(properties["processed_by"]), removeOnceIngested, properties["metadata_name"]}, nil
+}
+
+func (runRequest RunRequest) ToProperties() Properties {
+	properties := Properties{
+		"input_dir":    string(runRequest.InputPath),
+		"output_dir":   string(runRequest.OutputPath),
+		"processed_by": joinProcessorIds(runRequest.ProcessedBy),
+		"attempt":      strconv.Itoa(runRequest.Attempt),
+	}
+
+	if !runRequest.NotBefore.IsZero() {
+		properties["not_before"] = runRequest.NotBefore.UTC().Format(timestampFormat)
+	}
+
+	return properties
+}
+
+func RunRequestFromProperties(properties Properties, ingestDirQueue *IngestDirQueue) (RunRequest, error) {
+	for _, key := range []string{"input_dir", "output_dir"} {
+		if _, found := properties[key]; !found {
+			return RunRequest{}, fmt.Errorf("Run entry missing %s property", key)
+		}
+	}
+
+	runRequest := RunRequest{
+		Path(properties["input_dir"]),
+		Path(properties["output_dir"]),
+		splitProcessorIds(properties["processed_by"]),
+		ingestDirQueue,
+		0,
+		time.Time{},
+	}
+
+	if attemptStr, found := properties["attempt"]; found {
+		attempt, err := strconv.Atoi(attemptStr)
+
+		if err != nil {
+			return RunRequest{}, fmt.Errorf("Invalid attempt in run entry: %s", err.Error())
+		}
+
+		runRequest.Attempt = attempt
+	}
+
+	if notBeforeStr, found := properties["not_before"]; found {
+		notBefore, err := time.Parse(timestampFormat, notBeforeStr)
+
+		if err != nil {
+			return RunRequest{}, fmt.Errorf("Invalid not_before in run entry: %s", err.Error())
+		}
+
+		runRequest.NotBefore = notBefore
+	}
+
+	return runRequest, nil
+}
+
+type DispatchQueue struct {
+	*Spool
+}
+
+func NewDispatchQueue(path Path, capacity int) (*DispatchQueue, error) {
+	spool, err := NewSpool(path, capacity)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &DispatchQueue{spool}, nil
+}
+
+func (queue *DispatchQueue) Put(info DispatchInfo) error {
+	return queue.Spool.Put(info.ToProperties())
+}
+
+func (queue *DispatchQueue) Take(cancel <-chan struct{}) (DispatchInfo, SpoolEntry, error) {
+	entry, ok := queue.Spool.Take(cancel)
+
+	if !ok {
+		return DispatchInfo{}, entry, ErrQueueCanceled
+	}
+
+	info, err := DispatchInfoFromProperties(entry.Properties)
+	return info, entry, err
+}
+
+// QueuedDirs returns the directories holding data that is waiting to be
+// dispatched.
+func (queue *DispatchQueue) QueuedDirs() map[Path]bool {
+	dirs := make(map[Path]bool)
+
+	for _, properties := range queue.Entries() {
+		if info, err := DispatchInfoFromProperties(properties); err == nil {
+			dirs[info.MetadataPath.Parent()] = true
+		}
+	}
+
+	return dirs
+}
+
+type IngestDirQueue struct {
+	*Spool
+}
+
+func NewIngestDirQueue(path Path, capacity int) (*IngestDirQueue, error) {
+	spool, err := NewIndexedSpool(path, capacity, scanKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &IngestDirQueue{spool}, nil
+}
+
+func (queue *IngestDirQueue) Put(info IngestDirInfo) error {
+	return queue.Spool.Put(info.ToProperties())
+}
+
+func (queue *IngestDirQueue) Take(cancel <-chan struct{}) (IngestDirInfo, SpoolEntry, error) {
+	entry, ok := queue.Spool.Take(cancel)
+
+	if !ok {
+		return IngestDirInfo{}, entry, ErrQueueCanceled
+	}
+
+	info, err := IngestDirInfoFromProperties(entry.Properties)
+	return info, entry, err
+}
+
+// QueuedDirs returns the directories waiting to be ingested.
+func (queue *IngestDirQueue) QueuedDirs() map[Path]bool {
+	dirs := make(map[Path]bool)
+
+	for _, properties := range queue.Entries() {
+		if info, err := IngestDirInfoFromProperties(properties); err == nil {
+			dirs[info.IngestPath] = true
+		}
+	}
+
+	return dirs
+}
+
+// scanKey indexes ingest entries that scan a whole directory by the
+// directory, and leaves out ones for a single pair.
+func scanKey(properties Properties) string {
+	if len(properties["metadata_name"]) > 0 {
+		return ""
+	}
+
+	return properties["ingest_dir"]
+}
+
+// ScanQueued returns true if a scan of the whole directory at path is
+// waiting or in progress, as opposed to the ingest of a single pair.
+func (queue *IngestDirQueue) ScanQueued(path Path) bool {
+	return queue.Spool.Queued(string(path))
+}
+
+type RunRequestQueue struct {
+	*Spool
+	ingestDirQueue *IngestDirQueue
+}
+
+func NewRunRequestQueue(path Path, capacity int, ingestDirQueue *IngestDirQueue) (*RunRequestQueue, error) {
+	spool, err := NewSpool(path, capacity)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &RunRequestQueue{spool, ingestDirQueue}, nil
+}
+
+func (queue *RunRequestQueue) Put(runRequest RunRequest) error {
+	return queue.Spool.Put(runRequest.ToProperties())
+}
+
+func (queue *RunRequestQueue) Take(cancel <-chan struct{}) (RunRequest, SpoolEntry, error) {
+	entry, ok := queue.Spool.Take(cancel)
+
+	if !ok {
+		return RunRequest{}, entry, ErrQueueCanceled
+	}
+
+	runRequest, err := RunRequestFromProperties(entry.Properties, queue.ingestDirQueue)
+	return runRequest, entry, err
+}
+
+func (queue *RunRequestQueue) TryTake() (RunRequest, SpoolEntry, error) {
+	entry, ok := queue.Spool.TryTake()
+
+	if !ok {
+		return RunRequest{}, entry, ErrQueueEmpty
+	}
+
+	runRequest, err := RunRequestFromProperties(entry.Properties, queue.ingestDirQueue)
+	return runRequest, entry, err
+}
+
+// QueuedDirs returns the input directories of runs that are queued or
+// in progress.
+func (queue *RunRequestQueue) QueuedDirs() map[Path]bool {
+	dirs := make(map[Path]bool)
+
+	for _, properties := range queue.Entries() {
+		if runRequest, err := RunRequestFromProperties(properties, nil); err == nil {
+			dirs[runRequest.InputPath] = true
+		}
+	}
+
+	return dirs
+}
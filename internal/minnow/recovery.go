@@ -0,0 +1,226 @@
+package minnow
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+type WorkPathRecoverer struct {
+	workPath          Path
+	spoolPath         Path
+	processorRegistry *ProcessorRegistry
+	dispatchQueue     *DispatchQueue
+	ingestDirQueue    *IngestDirQueue
+	quarantine        *Quarantine
+	queuedDirs        map[Path]bool
+	logger            *log.Logger
+}
+
+func NewWorkPathRecoverer(workPath, spoolPath Path, processorRegistry *ProcessorRegistry, dispatchQueue *DispatchQueue, ingestDirQueue *IngestDirQueue, quarantine *Quarantine) *WorkPathRecoverer {
+	logger := log.New(os.Stdout, "Recovery: ", 0)
+	queuedDirs := make(map[Path]bool)
+	return &WorkPathRecoverer{workPath, spoolPath, processorRegistry, dispatchQueue, ingestDirQueue, quarantine, queuedDirs, logger}
+}
+
+// Run looks at everything left behind in the work path by a previous run
+// of minnow and puts it back into the pipeline, skipping anything that is
+// still in one of the queues.  It should be called once at startup,
+// before the ingester and dispatcher are started.
+func (recoverer *WorkPathRecoverer) Run() {
+	// Writes that never finished leave temporary files behind, which
+	// would otherwise be carried along with the directories they're in.
+	for _, dir := range []Path{recoverer.workPath, recoverer.quarantine.Path()} {
+		err := removeTempFiles(dir)
+
+		if err != nil {
+			recoverer.logger.Printf("Could not clean up temporary files in %s: %s", dir, err.Error())
+		}
+	}
+
+	paths, err := recoverer.workPath.Glob("*")
+
+	if err != nil {
+		recoverer.logger.Print(err.Error())
+		return
+	}
+
+	// Output directories are accounted for by the state of their run,
+	// so collect those first.
+	outputPaths := make(map[Path]bool)
+	states := make(map[Path]WorkState)
+
+	for _, path := range paths {
+		if !strings.HasSuffix(string(path), StateExtension) {
+			continue
+		}
+
+		dir := Path(strings.TrimSuffix(string(path), StateExtension))
+		state, err := ReadWorkState(dir)
+
+		if err != nil {
+			recoverer.logger.Printf("Could not read work state %s: %s", path, err.Error())
+			continue
+		}
+
+		states[dir] = state
+
+		if state.Kind == WorkKindRun {
+			outputPaths[state.OutputPath] = true
+
+			for _, failedOutputPath := range state.FailedOutputPaths {
+				outputPaths[failedOutputPath] = true
+			}
+		}
+	}
+
+	// Only look at the queues after the states have been read, so any
+	// work that moves between queues in the meantime is still seen.
+	for _, dirs := range []map[Path]bool{
+		recoverer.dispatchQueue.QueuedDirs(),
+		recoverer.ingestDirQueue.QueuedDirs(),
+		recoverer.processorRegistry.QueuedInputDirs(),
+	} {
+		for dir := range dirs {
+			recoverer.queuedDirs[dir] = true
+		}
+	}
+
+	for dir, state := range states {
+		switch state.Kind {
+		case WorkKindDispatch:
+			recoverer.recoverDispatch(dir, state)
+		case WorkKindRun:
+			recoverer.recoverRun(dir, state)
+		}
+	}
+
+	for _, path := range paths {
+		if !path.IsDir() || outputPaths[path] || path == recoverer.spoolPath || path == recoverer.quarantine.Path() || path.Name() == ReplayDirName || path.Name() == RejectedDirName {
+			continue
+		}
+
+		if _, found := states[path]; found {
+			continue
+		}
+
+		if strings.HasPrefix(path.Name(), "dispatch-") {
+			// the dispatch directory was created, but minnow died
+			// before its state could be written
+			recoverer.recoverDispatch(path, WorkState{Kind: WorkKindDispatch, ProcessedBy: make([]ProcessorId, 0)})
+			continue
+		}
+
+		recoverer.logger.Printf("Leaving unrecognized directory %s alone", path)
+	}
+}
+
+func (recoverer *WorkPathRecoverer) recoverDispatch(dispatchPath Path, state WorkState) {
+	if recoverer.queuedDirs[dispatchPath] {
+		return
+	}
+
+	if !dispatchPath.IsDir() {
+		RemoveWorkState(dispatchPath)
+		return
+	}
+
+	metadataPaths, err := dispatchPath.Glob("*" + PropertiesExtension)
+
+	if err != nil {
+		recoverer.logger.Print(err.Error())
+		return
+	}
+
+	for _, metadataPath := range metadataPaths {
+		dataPath := metadataPath.WithSuffix("")
+
+		if !dataPath.Exists() {
+			recoverer.logger.Printf("%s does not have corresponding data file", metadataPath)
+			continue
+		}
+
+		recoverer.logger.Printf("Re-dispatching %s", dataPath)
+		err = recoverer.dispatchQueue.Put(DispatchInfo{metadataPath, dataPath, state.ProcessedBy, state.ProcessorId})
+
+		if err != nil {
+			recoverer.logger.Printf("Could not queue %s for dispatch: %s", dataPath, err.Error())
+		}
+	}
+}
+
+func (recoverer *WorkPathRecoverer) recoverRun(inputPath Path, state WorkState) {
+	if recoverer.queuedDirs[inputPath] {
+		// the queue will run it again
+		return
+	}
+
+	switch state.Status {
+	case RunStatusQueued, RunStatusRunning:
+		if !inputPath.IsDir() {
+			RemoveWorkState(inputPath)
+			return
+		}
+
+		// Start over with an empty output directory, since a partial
+		// run may have left files behind.
+		err := clearDir(state.OutputPath)
+
+		if err != nil {
+			recoverer.logger.Printf("Could not clear output path %s: %s", state.OutputPath, err.Error())
+			return
+		}
+
+		err = UpdateRunStatus(inputPath, RunStatusQueued)
+
+		if err != nil {
+			recoverer.logger.Print(err.Error())
+			return
+		}
+
+		runRequest := RunRequest{inputPath, state.OutputPath, state.ProcessedBy, recoverer.ingestDirQueue, state.Attempt, time.Time{}}
+		err = recoverer.processorRegistry.SendToProcessorId(state.ProcessorId, runRequest)
+
+		if err != nil {
+			recoverer.logger.Printf("Could not re-queue %s: %s", inputPath, err.Error())
+			return
+		}
+
+		recoverer.logger.Printf("Re-queued %s for processor %s", inputPath, state.ProcessorId)
+	case RunStatusSucceeded:
+		// The run finished, but its output may not have been ingested
+		if state.OutputPath.IsDir() && !recoverer.queuedDirs[state.OutputPath] {
+			processedBy := append(state.ProcessedBy, state.ProcessorId)
+			recoverer.logger.Printf("Re-ingesting output %s", state.OutputPath)
+			err := recoverer.ingestDirQueue.Put(IngestDirInfo{state.OutputPath, time.Duration(0), processedBy, true, ""})
+
+			if err != nil {
+				recoverer.logger.Printf("Could not queue %s for ingest: %s", state.OutputPath, err.Error())
+				return
+			}
+		}
+
+		if inputPath.IsDir() {
+			inputPath.RmdirRecursive()
+		}
+
+		RemoveWorkState(inputPath)
+	case RunStatusFailed:
+		// minnow stopped before the run could be quarantined
+		processorName, err := recoverer.processorRegistry.ProcessorNameForId(state.ProcessorId)
+
+		if err != nil {
+			processorName = Path(state.ProcessorId).Name()
+		}
+
+		report := FailureReport{processorName, -1, time.Time{}, time.Time{}, state.Attempt + 1, "Recovered failed run at startup"}
+		_, err = recoverer.quarantine.Add(inputPath, report)
+
+		if err != nil {
+			recoverer.logger.Printf("Could not quarantine failed run %s: %s", inputPath, err.Error())
+		}
+	default:
+		recoverer.logger.Printf("Unknown status %s for run %s", state.Status, inputPath)
+	}
+}
@@ -0,0 +1,98 @@
+package minnow
+
+import (
+	"testing"
+)
+
+func TestWorkPathRecovererRun(t *testing.T) {
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	workPath.Mkdir()
+	defer workPath.RmdirRecursive()
+
+	// without workers, re-queued runs stay in the queue to be checked
+	definitionsPath := workPath.JoinPath("processors")
+	definitionsPath.Mkdir()
+	makeTestProcessorDefinition(t, "pool_size = 0", "true").Rename(definitionsPath.JoinPath("test"))
+	registry := makeTestRegistry(t, definitionsPath, workPath)
+	defer registry.Shutdown(0)
+	dispatchQueue, err := NewDispatchQueue(workPath.JoinPath("spool", "dispatch"), 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var processorId ProcessorId
+
+	for id := range registry.runRequestQueues {
+		processorId = id
+	}
+
+	inputPaths := make(map[string]Path)
+	outputPaths := make(map[string]Path)
+
+	for _, status := range []string{RunStatusQueued, RunStatusRunning, RunStatusSucceeded, RunStatusFailed} {
+		inputPath := workPath.JoinPath(Path(status + "-input"))
+		outputPath := workPath.JoinPath(Path(status + "-output"))
+		inputPath.Mkdir()
+		outputPath.Mkdir()
+		inputPath.JoinPath("data").WriteBytes([]byte("data"))
+		inputPath.JoinPath("data.properties").WriteBytes([]byte("type = test"))
+		outputPath.JoinPath("partial").WriteBytes([]byte("partial"))
+		state := WorkState{WorkKindRun, status, processorId, make([]ProcessorId, 0), inputPath, outputPath, 0, make([]Path, 0), 0, ""}
+		err = WriteWorkState(inputPath, state)
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		inputPaths[status] = inputPath
+		outputPaths[status] = outputPath
+	}
+
+	NewWorkPathRecoverer(workPath, workPath.JoinPath("spool"), registry, dispatchQueue, registry.ingestDirQueue, registry.quarantine).Run()
+
+	// runs that were queued or running are re-queued from scratch
+	queuedInputDirs := registry.QueuedInputDirs()
+
+	for _, status := range []string{RunStatusQueued, RunStatusRunning} {
+		if !queuedInputDirs[inputPaths[status]] {
+			t.Errorf("Run that was %s should have been re-queued", status)
+		}
+
+		if state, err := ReadWorkState(inputPaths[status]); err != nil || state.Status != RunStatusQueued {
+			t.Errorf("Run that was %s should now be %s", status, RunStatusQueued)
+		}
+
+		if leftovers, _ := outputPaths[status].Glob("*"); !outputPaths[status].IsDir() || len(leftovers) > 0 {
+			t.Errorf("Run that was %s should have an empty output directory", status)
+		}
+	}
+
+	// a succeeded run's output is ingested, and its input discarded
+	if !registry.ingestDirQueue.QueuedDirs()[outputPaths[RunStatusSucceeded]] {
+		t.Errorf("Output of the succeeded run should have been queued for ingest")
+	}
+
+	if inputPaths[RunStatusSucceeded].Exists() || StatePathFor(inputPaths[RunStatusSucceeded]).Exists() {
+		t.Errorf("Input of the succeeded run should have been discarded")
+	}
+
+	// a failed run is quarantined
+	if inputPaths[RunStatusFailed].Exists() || outputPaths[RunStatusFailed].Exists() || StatePathFor(inputPaths[RunStatusFailed]).Exists() {
+		t.Errorf("Failed run should have been moved out of the work path")
+	}
+
+	failedPaths, err := registry.quarantine.Path().Glob("*")
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(failedPaths) != 1 || !failedPaths[0].JoinPath("input", "data").Exists() || !failedPaths[0].JoinPath(FailureReportName).Exists() {
+		t.Errorf("Failed run should have been quarantined, found %v", failedPaths)
+	}
+
+	if len(dispatchQueue.QueuedDirs()) > 0 {
+		t.Errorf("Nothing should have been re-dispatched")
+	}
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package minnow
+
+import (
+	"os"
+	"syscall"
+)
+
+// FICLONE from linux/fs.h
+const ficlone = 0x40049409
+
+// reflinkFile makes destination a copy of source that shares its blocks
+// until either is written to, on filesystems that support it, like Btrfs
+// and XFS.
+func reflinkFile(source, destination Path) error {
+	return writeFileSynced(source, destination, func(to, from *os.File) error {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, to.Fd(), ficlone, from.Fd())
+
+		if errno != 0 {
+			return errno
+		}
+
+		return nil
+	})
+}
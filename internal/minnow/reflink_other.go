@@ -0,0 +1,12 @@
+//go:build !linux
+
+package minnow
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func reflinkFile(source, destination Path) error {
+	return fmt.Errorf("Reflinks are not supported on %s", runtime.GOOS)
+}
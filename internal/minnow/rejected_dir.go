@@ -0,0 +1,76 @@
+package minnow
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	RejectedDirName     = "rejected"
+	RejectionReportName = "rejection.properties"
+)
+
+// RejectedDir holds files that were left in an ingest directory without
+// ever becoming a complete pair, one directory per rejection, so producers
+// can see what went wrong.
+type RejectedDir struct {
+	path   Path
+	logger *log.Logger
+}
+
+func NewRejectedDir(path Path) (*RejectedDir, error) {
+	if !path.Exists() {
+		err := os.MkdirAll(string(path), 0700)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger := log.New(os.Stdout, "RejectedDir: ", 0)
+	return &RejectedDir{path, logger}, nil
+}
+
+func (rejectedDir *RejectedDir) Path() Path {
+	return rejectedDir.path
+}
+
+// Add moves the given files into a new directory named after the first of
+// them, along with a report giving the reason.  Files that are already gone
+// are skipped.
+func (rejectedDir *RejectedDir) Add(paths []Path, reason string, firstSeen time.Time) (Path, error) {
+	rejectedPath, err := makeRandomPath(rejectedDir.path, paths[0].Name())
+
+	if err != nil {
+		return "", err
+	}
+
+	report := Properties{
+		"original_path": string(paths[0]),
+		"reason":        reason,
+		"first_seen":    firstSeen.UTC().Format(timestampFormat),
+		"rejected":      time.Now().UTC().Format(timestampFormat),
+	}
+
+	err = rejectedPath.JoinPath(Path(RejectionReportName)).WriteBytes(report.ToBytes())
+
+	if err != nil {
+		return rejectedPath, err
+	}
+
+	for _, path := range paths {
+		if !path.Exists() {
+			continue
+		}
+
+		err = path.Move(rejectedPath.JoinPath(Path(path.Name())))
+
+		if err != nil {
+			return rejectedPath, err
+		}
+	}
+
+	rejectedDir.logger.Printf("Moved %s to %s: %s", paths[0], rejectedPath, reason)
+	return rejectedPath, nil
+}
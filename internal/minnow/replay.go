@@ -0,0 +1,361 @@
+package minnow
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ReplayDirName = "replay"
+
+	// how often a running minnow looks for replayed runs
+	replayPollInterval = time.Duration(5) * time.Second
+)
+
+// ReplayFilter picks which failed runs get replayed.  Zero values match
+// everything.
+type ReplayFilter struct {
+	ProcessorName string
+	Since         time.Time
+	Until         time.Time
+}
+
+// failedAt returns when a failed run finished, falling back to when it
+// started, then to when it was quarantined.
+func failedAt(failedPath Path, report Properties) time.Time {
+	for _, key := range []string{"finished", "started"} {
+		if timestamp, err := time.Parse(timestampFormat, report[key]); err == nil {
+			return timestamp
+		}
+	}
+
+	if info, err := os.Stat(string(failedPath)); err == nil {
+		return info.ModTime()
+	}
+
+	return time.Time{}
+}
+
+func (filter ReplayFilter) Matches(failedPath Path, report Properties) bool {
+	if len(filter.ProcessorName) > 0 && report["processor"] != filter.ProcessorName {
+		return false
+	}
+
+	timestamp := failedAt(failedPath, report)
+
+	if !filter.Since.IsZero() && timestamp.Before(filter.Since) {
+		return false
+	}
+
+	if !filter.Until.IsZero() && timestamp.After(filter.Until) {
+		return false
+	}
+
+	return true
+}
+
+// ReplayFailedRun moves the input of a quarantined run into replayPath,
+// where a running minnow picks it up and dispatches it again with its
+// original lineage.  If sameProcessor is true, it is only sent to the
+// processor that failed, rather than to every processor with a matching
+// hook.  The quarantined run is removed once its input has been moved.
+func ReplayFailedRun(failedPath, replayPath Path, sameProcessor bool) (Path, error) {
+	report, err := PropertiesFromFile(failedPath.JoinPath(Path(FailureReportName)))
+
+	if err != nil {
+		return "", fmt.Errorf("%s is not a failed run: %s", failedPath, err.Error())
+	}
+
+	metadataPaths, err := failedPath.JoinPath("input").Glob("*" + PropertiesExtension)
+
+	if err != nil {
+		return "", err
+	}
+
+	stagingPath, err := makeRandomPath(replayPath, "dispatch")
+
+	if err != nil {
+		return "", err
+	}
+
+	state := WorkState{Kind: WorkKindDispatch, ProcessedBy: splitProcessorIds(report["processed_by"])}
+
+	if sameProcessor {
+		state.ProcessorId = ProcessorId(report["processor_id"])
+	}
+
+	// The state is written under a temporary name, and only renamed
+	// once everything has been moved, so a half staged replay is never
+	// picked up.
+	statePath := StatePathFor(stagingPath)
+	tempStatePath := Path(string(statePath) + ".tmp")
+	err = tempStatePath.WriteBytes(state.ToProperties().ToBytes())
+
+	if err != nil {
+		return stagingPath, err
+	}
+
+	for _, metadataPath := range metadataPaths {
+		dataPath := metadataPath.WithSuffix("")
+
+		if !dataPath.Exists() {
+			continue
+		}
+
+		for _, path := range []Path{metadataPath, dataPath} {
+			err = path.Move(stagingPath.JoinPath(Path(path.Name())))
+
+			if err != nil {
+				return stagingPath, err
+			}
+		}
+	}
+
+	err = tempStatePath.Rename(statePath)
+
+	if err != nil {
+		return stagingPath, err
+	}
+
+	return stagingPath, failedPath.RmdirRecursive()
+}
+
+// Replayer moves replayed runs from the replay directory into the work
+// path and queues them for dispatch.
+type Replayer struct {
+	workPath      Path
+	replayPath    Path
+	dispatchQueue *DispatchQueue
+	logger        *log.Logger
+}
+
+func NewReplayer(workPath Path, dispatchQueue *DispatchQueue) (*Replayer, error) {
+	replayPath := workPath.JoinPath(Path(ReplayDirName))
+
+	if !replayPath.Exists() {
+		err := replayPath.Mkdir()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger := log.New(os.Stdout, "Replayer: ", 0)
+	return &Replayer{workPath, replayPath, dispatchQueue, logger}, nil
+}
+
+func (replayer *Replayer) Run() {
+	ticker := time.NewTicker(replayPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		replayer.Collect()
+	}
+}
+
+// Collect queues every replayed run that has been staged so far.  Replays
+// are new work as far as backpressure is concerned, so they wait while
+// the dispatch queue is full.
+func (replayer *Replayer) Collect() {
+	statePaths, err := replayer.replayPath.Glob("*" + StateExtension)
+
+	if err != nil {
+		replayer.logger.Print(err.Error())
+		return
+	}
+
+	for _, statePath := range statePaths {
+		if replayer.dispatchQueue.Full() {
+			return
+		}
+
+		stagingPath := Path(strings.TrimSuffix(string(statePath), StateExtension))
+		err = replayer.collect(stagingPath)
+
+		if err != nil {
+			replayer.logger.Printf("Could not replay %s: %s", stagingPath, err.Error())
+		}
+	}
+}
+
+func (replayer *Replayer) collect(stagingPath Path) error {
+	state, err := ReadWorkState(stagingPath)
+
+	if err != nil {
+		return err
+	}
+
+	if !stagingPath.IsDir() {
+		return RemoveWorkState(stagingPath)
+	}
+
+	// Write the state in the work path first, so the dispatch can be
+	// recovered if minnow dies partway through.
+	dispatchPath := replayer.workPath.JoinPath(Path(stagingPath.Name()))
+	err = WriteWorkState(dispatchPath, state)
+
+	if err != nil {
+		return err
+	}
+
+	err = stagingPath.Rename(dispatchPath)
+
+	if err != nil {
+		return err
+	}
+
+	err = RemoveWorkState(stagingPath)
+
+	if err != nil {
+		return err
+	}
+
+	metadataPaths, err := dispatchPath.Glob("*" + PropertiesExtension)
+
+	if err != nil {
+		return err
+	}
+
+	for _, metadataPath := range metadataPaths {
+		dataPath := metadataPath.WithSuffix("")
+		replayer.logger.Printf("Replaying %s", dataPath)
+		err = replayer.dispatchQueue.Put(DispatchInfo{metadataPath, dataPath, state.ProcessedBy, state.ProcessorId})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseOptionalTime(timeStr string) (time.Time, error) {
+	if len(timeStr) == 0 {
+		return time.Time{}, nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, timeStr)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time %s, must be RFC3339", timeStr)
+	}
+
+	return timestamp, nil
+}
+
+func replayUsage(flags *flag.FlagSet) {
+	fmt.Fprintln(flags.Output(), "Usage: minnow replay -config <config file> [options] [failed run dir...]")
+	fmt.Fprintln(flags.Output(), "Replays the given failed runs, or every failed run in failed_dir that matches the options.")
+	flags.PrintDefaults()
+}
+
+// ReplayCommand implements `minnow replay`.
+func ReplayCommand(args []string) int {
+	logger := log.New(os.Stdout, "Replay: ", 0)
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configStr := flags.String("config", "", "the config file minnow is started with (required)")
+	processorName := flags.String("processor", "", "only replay runs that failed in this processor")
+	sinceStr := flags.String("since", "", "only replay runs that failed at or after this RFC3339 time")
+	untilStr := flags.String("until", "", "only replay runs that failed at or before this RFC3339 time")
+	sameProcessor := flags.Bool("same-processor", false, "send runs back to the processor that failed, instead of every processor with a matching hook")
+	flags.Usage = func() { replayUsage(flags) }
+
+	if flags.Parse(args) != nil {
+		return 1
+	}
+
+	// Options after the first failed run dir wouldn't be parsed, so
+	// the config file is an option too, rather than the first argument.
+	if len(*configStr) == 0 {
+		logger.Print("Must specify a config file with -config")
+		replayUsage(flags)
+		return 1
+	}
+
+	since, err := parseOptionalTime(*sinceStr)
+
+	if err != nil {
+		logger.Print(err.Error())
+		return 1
+	}
+
+	until, err := parseOptionalTime(*untilStr)
+
+	if err != nil {
+		logger.Print(err.Error())
+		return 1
+	}
+
+	filter := ReplayFilter{*processorName, since, until}
+	config, err := ReadConfig(Path(*configStr))
+
+	if err != nil {
+		logger.Print(err.Error())
+		return 1
+	}
+
+	failedPaths := make([]Path, 0)
+
+	for _, arg := range flags.Args() {
+		failedPaths = append(failedPaths, Path(arg))
+	}
+
+	if len(failedPaths) == 0 {
+		infos, err := ioutil.ReadDir(string(config.FailedPath))
+
+		if err != nil {
+			logger.Print(err.Error())
+			return 1
+		}
+
+		for _, info := range infos {
+			if info.IsDir() {
+				failedPaths = append(failedPaths, config.FailedPath.JoinPath(Path(info.Name())))
+			}
+		}
+	}
+
+	replayPath := config.WorkPath.JoinPath(Path(ReplayDirName))
+	err = os.MkdirAll(string(replayPath), 0700)
+
+	if err != nil {
+		logger.Print(err.Error())
+		return 1
+	}
+
+	status := 0
+	replayed := 0
+
+	for _, failedPath := range failedPaths {
+		report, err := PropertiesFromFile(failedPath.JoinPath(Path(FailureReportName)))
+
+		if err != nil {
+			logger.Printf("Skipping %s, which is not a failed run: %s", failedPath, err.Error())
+			status = 1
+			continue
+		}
+
+		if !filter.Matches(failedPath, report) {
+			continue
+		}
+
+		_, err = ReplayFailedRun(failedPath, replayPath, *sameProcessor)
+
+		if err != nil {
+			logger.Printf("Could not replay %s: %s", failedPath, err.Error())
+			status = 1
+			continue
+		}
+
+		logger.Printf("Queued %s for replay", failedPath)
+		replayed++
+	}
+
+	logger.Printf("Replayed %d failed runs", replayed)
+	return status
+}
@@ -0,0 +1,118 @@
+package minnow
+
+import (
+	"testing"
+	"time"
+)
+
+func makeTestFailedRun(t *testing.T, failedDir Path, report Properties) Path {
+	failedPath, err := makeRandomPath(failedDir, report["processor"])
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	inputPath := failedPath.JoinPath("input")
+	inputPath.Mkdir()
+	inputPath.JoinPath("data.properties").WriteBytes([]byte("type = test"))
+	inputPath.JoinPath("data").WriteBytes([]byte("data"))
+	failedPath.JoinPath(Path(FailureReportName)).WriteBytes(report.ToBytes())
+	return failedPath
+}
+
+func TestReplayFailedRun(t *testing.T) {
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	workPath.Mkdir()
+	defer workPath.RmdirRecursive()
+
+	failedDir := workPath.JoinPath("failed")
+	failedDir.Mkdir()
+	report := Properties{"processor": "test", "processor_id": "/processors/test", "processed_by": "/processors/a"}
+	failedPath := makeTestFailedRun(t, failedDir, report)
+
+	dispatchQueue, err := NewDispatchQueue(workPath.JoinPath("spool"), 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	replayer, err := NewReplayer(workPath, dispatchQueue)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	_, err = ReplayFailedRun(failedPath, replayer.replayPath, true)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if failedPath.Exists() {
+		t.Errorf("Replayed run should be removed from the failed directory")
+	}
+
+	replayer.Collect()
+
+	if dispatchQueue.Len() != 1 {
+		t.Fatalf("Expected one replayed item to be queued, got %d", dispatchQueue.Len())
+	}
+
+	info, _, err := dispatchQueue.Take(nil)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if info.ProcessorId != "/processors/test" {
+		t.Errorf("Replay should go to the failed processor, got %s", info.ProcessorId)
+	}
+
+	if !info.AlreadyProcessedBy("/processors/a") || info.AlreadyProcessedBy("/processors/test") {
+		t.Errorf("Replay lost its lineage: %v", info.ProcessedBy)
+	}
+
+	if !info.DataPath.Exists() || info.DataPath.Parent().Parent() != workPath {
+		t.Errorf("Replayed data should be in the work path, got %s", info.DataPath)
+	}
+}
+
+func TestReplayFilter(t *testing.T) {
+	finished := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	report := Properties{"processor": "test", "finished": finished.Format(timestampFormat)}
+
+	filters := map[bool][]ReplayFilter{
+		true: {
+			{},
+			{ProcessorName: "test"},
+			{Since: finished.Add(-time.Hour), Until: finished.Add(time.Hour)},
+		},
+		false: {
+			{ProcessorName: "other"},
+			{Since: finished.Add(time.Hour)},
+			{Until: finished.Add(-time.Hour)},
+		},
+	}
+
+	for expected, filterList := range filters {
+		for _, filter := range filterList {
+			if filter.Matches("/nonexistent", report) != expected {
+				t.Errorf("Expected %v to match: %v", filter, expected)
+			}
+		}
+	}
+}
+
+func TestReplayCommandRequiresConfig(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"config.properties"}, // the config file isn't a positional argument
+		{"-processor", "test", "/tmp/failed-run"},
+	}
+
+	for _, args := range tests {
+		if ReplayCommand(args) == 0 {
+			t.Errorf("Replay without -config should have failed: %v", args)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package minnow
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	SpoolEntryExtension = ".entry"
+)
+
+var (
+	ErrQueueCanceled = errors.New("Queue wait canceled")
+	ErrQueueEmpty    = errors.New("Queue is empty")
+)
+
+type SpoolEntry struct {
+	path       Path
+	Properties Properties
+}
+
+// SpoolKeyFunc picks the key an entry is indexed by, or returns an empty
+// string to leave it out of the index.
+type SpoolKeyFunc func(Properties) string
+
+// Spool is a persistent FIFO queue backed by a directory.  Each entry is
+// written once to its own file and removed when it is acknowledged, so
+// anything queued or in progress survives a restart.  The entries are also
+// kept in memory, so looking through them doesn't touch the disk.
+type Spool struct {
+	path      Path
+	capacity  int // zero means never full
+	pending   []Path
+	inFlight  map[Path]bool
+	entries   map[Path]Properties
+	keyOf     SpoolKeyFunc // nil if the spool isn't indexed
+	keyCounts map[string]int
+	nextSeq   uint64
+	mutex     *sync.Mutex
+	readyChan chan struct{}
+	logger    *log.Logger
+}
+
+func NewSpool(path Path, capacity int) (*Spool, error) {
+	return NewIndexedSpool(path, capacity, nil)
+}
+
+// NewIndexedSpool is like NewSpool, but keeps count of the entries with
+// each key, which can be checked with Queued.
+func NewIndexedSpool(path Path, capacity int, keyOf SpoolKeyFunc) (*Spool, error) {
+	if !path.Exists() {
+		err := os.MkdirAll(string(path), 0700)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// clean up entries that were never finished being written
+	err := removeTempFiles(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entryPaths, err := path.Glob("*" + SpoolEntryExtension)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// entry names are zero padded sequence numbers, so sorting by
+	// name puts them back in order
+	sort.Slice(entryPaths, func(i, j int) bool { return entryPaths[i] < entryPaths[j] })
+	nextSeq := uint64(0)
+
+	if len(entryPaths) > 0 {
+		lastName := strings.TrimSuffix(entryPaths[len(entryPaths)-1].Name(), SpoolEntryExtension)
+		fmt.Sscanf(lastName, "%d", &nextSeq)
+		nextSeq++
+	}
+
+	logger := log.New(os.Stdout, "Spool: ", 0)
+	spool := &Spool{
+		path,
+		capacity,
+		make([]Path, 0, len(entryPaths)),
+		make(map[Path]bool),
+		make(map[Path]Properties),
+		keyOf,
+		make(map[string]int),
+		nextSeq,
+		new(sync.Mutex),
+		make(chan struct{}, 1),
+		logger,
+	}
+
+	for _, entryPath := range entryPaths {
+		properties, err := PropertiesFromFile(entryPath)
+
+		if err != nil {
+			logger.Printf("Discarding unreadable entry %s: %s", entryPath, err.Error())
+			entryPath.Unlink()
+			continue
+		}
+
+		spool.add(entryPath, properties)
+	}
+
+	return spool, nil
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (spool *Spool) full() bool {
+	return spool.capacity > 0 && len(spool.pending)+len(spool.inFlight) >= spool.capacity
+}
+
+// Full returns true if the spool is at or over capacity.  Capacity doesn't
+// limit Put, it's the point at which ingest of new work pauses.
+func (spool *Spool) Full() bool {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	return spool.full()
+}
+
+// Put persists properties as a new entry.  Put never waits, even if the
+// spool is over capacity, so moving work between queues can't deadlock.
+// Producers of new work should check Full first.
+func (spool *Spool) Put(properties Properties) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	return spool.append(properties)
+}
+
+func (spool *Spool) append(properties Properties) error {
+	name := fmt.Sprintf("%020d%s", spool.nextSeq, SpoolEntryExtension)
+	entryPath := spool.path.JoinPath(Path(name))
+
+	// WriteBytes renames the entry into place once it's complete, so a
+	// half written entry is never picked up
+	err := entryPath.WriteBytes(properties.ToBytes())
+
+	if err != nil {
+		return err
+	}
+
+	spool.nextSeq++
+	spool.add(entryPath, properties)
+	notify(spool.readyChan)
+	return nil
+}
+
+// add puts an entry that's been written at the back of the spool.
+func (spool *Spool) add(entryPath Path, properties Properties) {
+	spool.pending = append(spool.pending, entryPath)
+	spool.entries[entryPath] = properties
+
+	if spool.keyOf != nil {
+		if key := spool.keyOf(properties); len(key) > 0 {
+			spool.keyCounts[key]++
+		}
+	}
+}
+
+// remove forgets an entry that has been acknowledged.
+func (spool *Spool) remove(entryPath Path) {
+	properties, found := spool.entries[entryPath]
+
+	if !found {
+		return
+	}
+
+	delete(spool.entries, entryPath)
+	delete(spool.inFlight, entryPath)
+
+	if spool.keyOf != nil {
+		if key := spool.keyOf(properties); len(key) > 0 {
+			spool.keyCounts[key]--
+
+			if spool.keyCounts[key] <= 0 {
+				delete(spool.keyCounts, key)
+			}
+		}
+	}
+}
+
+// Take blocks until an entry is available and returns it.  The entry
+// stays on disk until it is passed to Ack.  Returns false if cancel is
+// closed first.
+func (spool *Spool) Take(cancel <-chan struct{}) (SpoolEntry, bool) {
+	for {
+		select {
+		case <-cancel:
+			return SpoolEntry{}, false
+		default:
+		}
+
+		if entry, ok := spool.TryTake(); ok {
+			return entry, true
+		}
+
+		select {
+		case <-spool.readyChan:
+		case <-cancel:
+			return SpoolEntry{}, false
+		}
+	}
+}
+
+// TryTake is like Take, but returns false right away if the spool is
+// empty.
+func (spool *Spool) TryTake() (SpoolEntry, bool) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	if len(spool.pending) == 0 {
+		return SpoolEntry{}, false
+	}
+
+	entryPath := spool.pending[0]
+	spool.pending = spool.pending[1:]
+	spool.inFlight[entryPath] = true
+
+	// wake up another consumer if there's more to do
+	if len(spool.pending) > 0 {
+		notify(spool.readyChan)
+	}
+
+	return SpoolEntry{entryPath, spool.entries[entryPath]}, true
+}
+
+// Ack removes an entry once it has been handled.
+func (spool *Spool) Ack(entry SpoolEntry) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	spool.remove(entry.path)
+	return entry.path.Unlink()
+}
+
+// Release puts an entry that was taken, but not handled, back at the
+// front of the spool.
+func (spool *Spool) Release(entry SpoolEntry) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	if !spool.inFlight[entry.path] {
+		return
+	}
+
+	delete(spool.inFlight, entry.path)
+	spool.pending = append([]Path{entry.path}, spool.pending...)
+	notify(spool.readyChan)
+}
+
+// Entries returns the properties of every entry that has not been
+// acknowledged, including ones that are in flight.
+func (spool *Spool) Entries() []Properties {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	entries := make([]Properties, 0, len(spool.entries))
+
+	for _, properties := range spool.entries {
+		entries = append(entries, properties)
+	}
+
+	return entries
+}
+
+// Queued returns true if an entry with the given key hasn't been
+// acknowledged yet.
+func (spool *Spool) Queued(key string) bool {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	return spool.keyCounts[key] > 0
+}
+
+func (spool *Spool) Len() int {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	return len(spool.pending) + len(spool.inFlight)
+}
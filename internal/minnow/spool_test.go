@@ -0,0 +1,190 @@
+package minnow
+
+import (
+	"testing"
+)
+
+func TestSpoolSurvivesReopen(t *testing.T) {
+	path := Path("/tmp/minnow-spool-" + randomString(20))
+	defer path.RmdirRecursive()
+	spool, err := NewSpool(path, 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, value := range []string{"a", "b", "c"} {
+		err = spool.Put(Properties{"value": value})
+
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}
+
+	entry, ok := spool.Take(nil)
+
+	if !ok || entry.Properties["value"] != "a" {
+		t.Errorf("Expected first entry to be a, got %v", entry.Properties)
+	}
+
+	spool.Ack(entry)
+
+	// b is taken but never acknowledged, so it should come back
+	spool.Take(nil)
+	reopened, err := NewSpool(path, 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if reopened.Len() != 2 {
+		t.Errorf("Expected 2 entries after reopening, got %d", reopened.Len())
+	}
+
+	for _, expected := range []string{"b", "c"} {
+		entry, ok := reopened.Take(nil)
+
+		if !ok || entry.Properties["value"] != expected {
+			t.Errorf("Expected entry %s, got %v", expected, entry.Properties)
+		}
+	}
+
+	err = reopened.Put(Properties{"value": "d"})
+
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	entry, ok = reopened.Take(nil)
+
+	if !ok || entry.Properties["value"] != "d" {
+		t.Errorf("Expected entry d after reopening, got %v", entry.Properties)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	path := Path("/tmp/minnow-spool-" + randomString(20))
+	defer path.RmdirRecursive()
+	spool, err := NewSpool(path, 1)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	spool.Put(Properties{"value": "a"})
+
+	if !spool.Full() {
+		t.Errorf("Spool should be full")
+	}
+
+	// Put never waits, so work can always move between queues
+	err = spool.Put(Properties{"value": "b"})
+
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	entry, _ := spool.Take(nil)
+	spool.Ack(entry)
+
+	if !spool.Full() {
+		t.Errorf("Spool should still be full with an entry over capacity")
+	}
+
+	entry, _ = spool.Take(nil)
+	spool.Ack(entry)
+
+	if spool.Full() {
+		t.Errorf("Spool should not be full once emptied")
+	}
+}
+
+func TestSpoolTakeCanceled(t *testing.T) {
+	path := Path("/tmp/minnow-spool-" + randomString(20))
+	defer path.RmdirRecursive()
+	spool, err := NewSpool(path, 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+
+	if _, ok := spool.Take(cancel); ok {
+		t.Errorf("Take should not return an entry once canceled")
+	}
+}
+
+func TestSpoolRelease(t *testing.T) {
+	path := Path("/tmp/minnow-spool-" + randomString(20))
+	defer path.RmdirRecursive()
+	spool, err := NewSpool(path, 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if _, ok := spool.TryTake(); ok {
+		t.Errorf("TryTake should not return an entry from an empty spool")
+	}
+
+	spool.Put(Properties{"n": "1"})
+	spool.Put(Properties{"n": "2"})
+	entry, ok := spool.TryTake()
+
+	if !ok || entry.Properties["n"] != "1" {
+		t.Fatalf("Expected the first entry")
+	}
+
+	spool.Release(entry)
+	entry, ok = spool.TryTake()
+
+	if !ok || entry.Properties["n"] != "1" {
+		t.Errorf("Released entry should be taken again first")
+	}
+}
+
+func TestSpoolIndex(t *testing.T) {
+	path := Path("/tmp/minnow-spool-" + randomString(20))
+	defer path.RmdirRecursive()
+	keyOf := func(properties Properties) string { return properties["key"] }
+	spool, err := NewIndexedSpool(path, 0, keyOf)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	spool.Put(Properties{"key": "a"})
+	spool.Put(Properties{"key": "a"})
+	spool.Put(Properties{"key": ""})
+
+	if !spool.Queued("a") || spool.Queued("b") || spool.Queued("") {
+		t.Errorf("Only a should be queued")
+	}
+
+	// the index is rebuilt from the entries on disk
+	spool, err = NewIndexedSpool(path, 0, keyOf)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for i := 0; i < 2; i++ {
+		if !spool.Queued("a") {
+			t.Errorf("a should still be queued after %d acks", i)
+		}
+
+		entry, _ := spool.TryTake()
+
+		if !spool.Queued("a") {
+			t.Errorf("a should still be queued while it's in flight")
+		}
+
+		spool.Ack(entry)
+	}
+
+	if spool.Queued("a") {
+		t.Errorf("a should not be queued once both entries are acknowledged")
+	}
+}
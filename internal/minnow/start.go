@@ -3,12 +3,22 @@ package minnow
 import (
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+const (
+	SpoolDirName = "spool"
+)
+
 func Start(args []string) int {
 	logger := log.New(os.Stdout, "Minnow: ", 0)
 
+	if len(args) > 1 && args[1] == "replay" {
+		return ReplayCommand(args[2:])
+	}
+
 	if len(args) != 2 {
 		logger.Print("Must specify a config file")
 		return 1
@@ -21,34 +31,117 @@ func Start(args []string) int {
 		return 1
 	}
 
-	dispatchChan := make(chan DispatchInfo, 1000)
-	ingestDirChan := make(chan IngestDirInfo, 1000)
-	defer close(ingestDirChan)
-	defer close(dispatchChan)
+	SetLogLevel(config.LogLevel)
+
+	// Queued work is spooled to disk in the work path so it survives a
+	// restart.
+	spoolPath := config.WorkPath.JoinPath(Path(SpoolDirName))
+	dispatchQueue, err := NewDispatchQueue(spoolPath.JoinPath(Path("dispatch")), config.QueueCapacity)
+
+	if err != nil {
+		logger.Print(err.Error())
+		return 1
+	}
+
+	ingestDirQueue, err := NewIngestDirQueue(spoolPath.JoinPath(Path("ingest")), config.QueueCapacity)
+
+	if err != nil {
+		logger.Print(err.Error())
+		return 1
+	}
+
+	quarantine, err := NewQuarantine(config.FailedPath)
+
+	if err != nil {
+		logger.Print(err.Error())
+		return 1
+	}
+
+	processorRegistry, err := NewProcessorRegistry(config.ProcessorDefinitionsPath, config.ProcessorDefaults(), spoolPath, config.QueueCapacity, config.QueueOverflow, ingestDirQueue, quarantine)
 
-	processorRegistry, err := NewProcessorRegistry(config.ProcessorDefinitionsPath)
+	if err != nil {
+		logger.Print(err.Error())
+		return 1
+	}
+
+	dispatcher, err := NewDispatcher(config.WorkPath, dispatchQueue, ingestDirQueue, processorRegistry, config.CopyMode)
 
 	if err != nil {
 		logger.Print(err.Error())
 		return 1
 	}
 
-	dispatcher, err := NewDispatcher(config.WorkPath, dispatchChan, ingestDirChan, processorRegistry)
+	rejectedDir, err := NewRejectedDir(config.RejectedPath)
 
 	if err != nil {
 		logger.Print(err.Error())
 		return 1
 	}
 
-	directoryIngester := NewDirectoryIngester(config.WorkPath, ingestDirChan, dispatchChan)
+	directoryIngester := NewDirectoryIngester(config.WorkPath, ingestDirQueue, dispatchQueue, processorRegistry, quarantine, rejectedDir, config.IngestSettings())
+	replayer, err := NewReplayer(config.WorkPath, dispatchQueue)
+
+	if err != nil {
+		logger.Print(err.Error())
+		return 1
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	stopChan := make(chan struct{})
+
+	go func() {
+		sig := <-signalChan
+		logger.Printf("Received %s, shutting down", sig)
+		close(stopChan)
+	}()
+
+	// pick up anything left behind by a previous run before ingesting more
+	NewWorkPathRecoverer(config.WorkPath, spoolPath, processorRegistry, dispatchQueue, ingestDirQueue, quarantine).Run()
 
 	go dispatcher.Run()
 	go directoryIngester.Run()
-	go processorRegistry.Run()
+	go replayer.Run()
+	go processorRegistry.Run(config.ProcessorPollInterval)
+
+	// Pairs are picked up as soon as they're written where the ingest
+	// directory can be watched.  Scans catch whatever the watcher misses.
+	for _, source := range config.IngestSources {
+		ingestWatcher, err := NewIngestWatcher(source, config.IngestCompletion, ingestDirQueue)
 
-	for range time.Tick(config.IngestMinAge) {
-		ingestDirChan <- IngestDirInfo{config.IngestPath, config.IngestMinAge, make([]ProcessorId, 0), false}
+		if err != nil {
+			logger.Printf("Could not watch %s, relying on scans: %s", source.Path, err.Error())
+			continue
+		}
+
+		defer ingestWatcher.Close()
+		go ingestWatcher.Run()
 	}
 
-	return 0
+	ticker := time.NewTicker(config.IngestScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, source := range config.IngestSources {
+				// don't pile up scans while ingest is paused
+				if ingestDirQueue.ScanQueued(source.Path) {
+					continue
+				}
+
+				ingestDirInfo := IngestDirInfo{source.Path, source.MinAge, make([]ProcessorId, 0), false, ""}
+				err := ingestDirQueue.Put(ingestDirInfo)
+
+				if err != nil {
+					logger.Printf("Could not queue scan of %s: %s", source.Path, err.Error())
+				}
+			}
+		case <-stopChan:
+			ticker.Stop()
+			processorRegistry.Shutdown(config.ShutdownGracePeriod)
+			logger.Print("Shutdown complete")
+			return 0
+		}
+	}
 }
@@ -2,12 +2,16 @@ package minnow
 
 import (
 	"fmt"
-	"io"
-	"os"
+	"io/ioutil"
 	"strings"
 	"time"
 )
 
+const (
+	// format for timestamps written to properties files
+	timestampFormat = time.RFC3339Nano
+)
+
 func nanoTimestamp(now time.Time) string {
 	return fmt.Sprintf("%04d-%02d-%02dT%02d:%02d:%02d:%09d",
 		now.Year(), now.Month(), now.Day(),
@@ -34,49 +38,43 @@ func makeRandomPath(baseDir Path, purpose string) (Path, error) {
 	return path, nil
 }
 
-func CopyFile(source, destination Path) error {
-	if source.IsDir() {
-		return fmt.Errorf("Cannot use Copy for a directory: %s", source)
-	}
+// clearDir makes sure dir exists and is empty.
+func clearDir(dir Path) error {
+	if dir.Exists() {
+		contents, err := ioutil.ReadDir(string(dir))
 
-	if destination.IsDir() {
-		destination = destination.JoinPath(Path(source.Name()))
-	}
+		if err != nil {
+			return err
+		}
 
-	if source == destination {
-		return fmt.Errorf("Copy source and destination are the same.  Nothing to do.")
-	}
+		if len(contents) == 0 {
+			return nil
+		}
 
-	from, err := os.Open(string(source))
+		err = dir.RmdirRecursive()
 
-	if err != nil {
-		return err
+		if err != nil {
+			return err
+		}
 	}
 
-	defer from.Close()
-
-	perms, err := source.Permissions()
+	return dir.Mkdir()
+}
 
-	if err != nil {
-		return err
+func CopyFile(source, destination Path) error {
+	if source.IsDir() {
+		return fmt.Errorf("Cannot use Copy for a directory: %s", source)
 	}
 
-	// Copy source permissions, making sure that the destination is
-	// at least readable and writable.
-	perms = perms | 0600
-	to, err := os.OpenFile(string(destination), os.O_RDWR|os.O_CREATE, perms)
-
-	if err != nil {
-		return err
+	if destination.IsDir() {
+		destination = destination.JoinPath(Path(source.Name()))
 	}
 
-	defer to.Close()
-
-	_, err = io.Copy(to, from)
-
-	if err != nil {
-		return err
+	if source == destination {
+		return fmt.Errorf("Copy source and destination are the same.  Nothing to do.")
 	}
 
-	return nil
+	// the copy is synced before it shows up, so it's never dispatched
+	// half written
+	return copyFileSynced(source, destination)
 }
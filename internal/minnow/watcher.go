@@ -0,0 +1,69 @@
+package minnow
+
+import (
+	"time"
+)
+
+const (
+	// how many events can be waiting before the watcher stops reading more
+	watchEventBuffer = 1024
+)
+
+// WatchEvent is a change to something under a watched directory.
+type WatchEvent struct {
+	Path     Path
+	Complete bool // a file finished being written, or was moved in
+	Overflow bool // events were lost, so the directory needs to be rescanned
+}
+
+// DirWatcher reports changes to the files under a directory, waiting for
+// things to settle so a burst of changes is reported once.
+type DirWatcher struct {
+	changes  chan struct{}
+	debounce time.Duration
+	stop     func() error
+}
+
+// NewDirWatcher starts watching path and everything under it.  Returns an
+// error if watching isn't supported, in which case callers should fall
+// back to polling.
+func NewDirWatcher(path Path, debounce time.Duration) (*DirWatcher, error) {
+	events, stop, err := watchDir(path, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	watcher := &DirWatcher{make(chan struct{}, 1), debounce, stop}
+	go watcher.run(events)
+	return watcher, nil
+}
+
+func (watcher *DirWatcher) run(events <-chan WatchEvent) {
+	var settled <-chan time.Time
+
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+
+			// start waiting over with each change
+			settled = time.After(watcher.debounce)
+		case <-settled:
+			settled = nil
+			notify(watcher.changes)
+		}
+	}
+}
+
+// Changes returns a channel that receives once things have settled after
+// one or more changes.
+func (watcher *DirWatcher) Changes() <-chan struct{} {
+	return watcher.changes
+}
+
+func (watcher *DirWatcher) Close() error {
+	return watcher.stop()
+}
@@ -0,0 +1,146 @@
+//go:build linux
+
+package minnow
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE |
+		syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+)
+
+type inotifyWatcher struct {
+	path      Path
+	recursive bool
+	fd        int
+	file      *os.File
+	dirs      map[int]Path // watch descriptor to directory
+	events    chan WatchEvent
+}
+
+func watchDir(path Path, recursive bool) (<-chan WatchEvent, func() error, error) {
+	// A non-blocking descriptor lets the runtime poll it, so closing the
+	// file wakes up the reader.
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file := os.NewFile(uintptr(fd), "inotify")
+	watcher := &inotifyWatcher{path, recursive, fd, file, make(map[int]Path), make(chan WatchEvent, watchEventBuffer)}
+	err = watcher.addWatches()
+
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	go watcher.read()
+	return watcher.events, file.Close, nil
+}
+
+// addWatches watches the watcher's path, and every directory under it if
+// it's recursive.  Adding a watch on a directory that is already watched
+// just updates it.
+func (watcher *inotifyWatcher) addWatches() error {
+	if !watcher.recursive {
+		return watcher.addWatch(string(watcher.path))
+	}
+
+	return filepath.Walk(string(watcher.path), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// removed while walking
+			return nil
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		return watcher.addWatch(path)
+	})
+}
+
+func (watcher *inotifyWatcher) addWatch(path string) error {
+	wd, err := syscall.InotifyAddWatch(watcher.fd, path, inotifyMask)
+
+	if err != nil {
+		return err
+	}
+
+	watcher.dirs[wd] = Path(path)
+	return nil
+}
+
+func (watcher *inotifyWatcher) read() {
+	defer close(watcher.events)
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+		n, err := watcher.file.Read(buffer)
+
+		if err != nil {
+			return
+		}
+
+		events := make([]WatchEvent, 0)
+		newDirs := false
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// events were dropped, so the caller has to look for itself
+				events = append(events, WatchEvent{Overflow: true})
+				newDirs = true
+				continue
+			}
+
+			dir, found := watcher.dirs[int(event.Wd)]
+
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(watcher.dirs, int(event.Wd))
+				continue
+			}
+
+			if !found {
+				continue
+			}
+
+			// the name is padded with NUL bytes
+			name := string(buffer[nameStart:offset])
+
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+
+			isDir := event.Mask&syscall.IN_ISDIR != 0
+			moved := event.Mask&syscall.IN_MOVED_TO != 0
+			complete := !isDir && (moved || event.Mask&syscall.IN_CLOSE_WRITE != 0)
+
+			if isDir && (moved || event.Mask&syscall.IN_CREATE != 0) {
+				newDirs = true
+			}
+
+			events = append(events, WatchEvent{dir.JoinPath(Path(name)), complete, false})
+		}
+
+		// new directories need watches of their own
+		if newDirs && watcher.recursive {
+			watcher.addWatches()
+		}
+
+		for _, event := range events {
+			watcher.events <- event
+		}
+	}
+}
@@ -0,0 +1,100 @@
+//go:build linux
+
+package minnow
+
+import (
+	"testing"
+	"time"
+)
+
+func waitForChange(watcher *DirWatcher, timeout time.Duration) bool {
+	select {
+	case <-watcher.Changes():
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestDirWatcher(t *testing.T) {
+	path := Path("/tmp/minnow-watch-" + randomString(20))
+	path.Mkdir()
+	defer path.RmdirRecursive()
+
+	watcher, err := NewDirWatcher(path, time.Duration(200)*time.Millisecond)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	defer watcher.Close()
+
+	// a burst of changes is reported once
+	subdirPath := path.JoinPath("processor")
+	subdirPath.Mkdir()
+	subdirPath.JoinPath("config.properties").WriteBytes([]byte("start_script = start.sh"))
+	subdirPath.JoinPath("start.sh").WriteBytes([]byte("#!/bin/sh"))
+
+	if !waitForChange(watcher, time.Duration(2)*time.Second) {
+		t.Fatalf("Expected a change")
+	}
+
+	if waitForChange(watcher, time.Duration(500)*time.Millisecond) {
+		t.Errorf("Burst of changes should only be reported once")
+	}
+
+	// directories created after the watcher started are watched too
+	subdirPath.JoinPath("start.sh").WriteBytes([]byte("#!/bin/sh\ntrue"))
+
+	if !waitForChange(watcher, time.Duration(2)*time.Second) {
+		t.Errorf("Expected a change in a new directory")
+	}
+}
+
+func TestIngestWatcher(t *testing.T) {
+	ingestPath := Path("/tmp/minnow-ingest-" + randomString(20))
+	spoolPath := Path("/tmp/minnow-spool-" + randomString(20))
+	ingestPath.Mkdir()
+	defer ingestPath.RmdirRecursive()
+	defer spoolPath.RmdirRecursive()
+
+	ingestDirQueue, err := NewIngestDirQueue(spoolPath, 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	watcher, err := NewIngestWatcher(IngestSource{Name: DefaultIngestSourceName, Path: ingestPath, MinAge: time.Duration(1) * time.Hour}, IngestCompletionAge, ingestDirQueue)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	defer watcher.Close()
+	go watcher.Run()
+
+	// nothing is queued until both halves are written
+	ingestPath.JoinPath("data.txt").WriteBytes([]byte("data"))
+	time.Sleep(time.Duration(200) * time.Millisecond)
+
+	if ingestDirQueue.Len() != 0 {
+		t.Fatalf("Pair should not be queued before its metadata is written")
+	}
+
+	ingestPath.JoinPath("data.txt" + PropertiesExtension).WriteBytes([]byte("type = test"))
+	deadline := time.Now().Add(time.Duration(2) * time.Second)
+
+	for ingestDirQueue.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Duration(20) * time.Millisecond)
+	}
+
+	info, _, err := ingestDirQueue.Take(nil)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if info.MetadataName != "data.txt"+PropertiesExtension {
+		t.Errorf("Expected data.txt to be queued, got %s", info.MetadataName)
+	}
+}
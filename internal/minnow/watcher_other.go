@@ -0,0 +1,12 @@
+//go:build !linux
+
+package minnow
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func watchDir(path Path, recursive bool) (<-chan WatchEvent, func() error, error) {
+	return nil, nil, fmt.Errorf("Watching %s is not supported on %s", path, runtime.GOOS)
+}
@@ -0,0 +1,209 @@
+package minnow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	StateExtension = ".state"
+
+	WorkKindDispatch = "dispatch"
+	WorkKindRun      = "run"
+
+	RunStatusQueued    = "queued"
+	RunStatusRunning   = "running"
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+)
+
+// WorkState is persisted next to each directory minnow creates in the
+// work path, so work in progress can be reconstructed after a crash.
+type WorkState struct {
+	Kind        string
+	Status      string
+	ProcessorId ProcessorId
+	ProcessedBy []ProcessorId
+	InputPath   Path
+	OutputPath  Path
+	Attempt     int
+	// output directories of earlier attempts, kept for their logs
+	FailedOutputPaths []Path
+	// transient failures so far, which don't count toward Attempt
+	TransientRetries int
+	// where a failed run is being moved to, once that has started
+	QuarantinePath Path
+}
+
+func StatePathFor(dir Path) Path {
+	return Path(string(dir) + StateExtension)
+}
+
+func joinProcessorIds(processorIds []ProcessorId) string {
+	ids := make([]string, 0, len(processorIds))
+
+	for _, processorId := range processorIds {
+		ids = append(ids, string(processorId))
+	}
+
+	return strings.Join(ids, string(os.PathListSeparator))
+}
+
+func splitProcessorIds(idsStr string) []ProcessorId {
+	processorIds := make([]ProcessorId, 0)
+
+	for _, id := range filepath.SplitList(idsStr) {
+		if len(id) > 0 {
+			processorIds = append(processorIds, ProcessorId(id))
+		}
+	}
+
+	return processorIds
+}
+
+// Work directory names have timestamps with colons in them, so lists of
+// them are stored one path per numbered key, like failed_output_dir.1,
+// rather than joined with a separator.
+
+func putPaths(properties Properties, key string, paths []Path) {
+	for i, path := range paths {
+		properties[fmt.Sprintf("%s.%d", key, i+1)] = string(path)
+	}
+}
+
+func getPaths(properties Properties, key string) []Path {
+	paths := make([]Path, 0)
+
+	for i := 1; ; i++ {
+		path, found := properties[fmt.Sprintf("%s.%d", key, i)]
+
+		if !found {
+			return paths
+		}
+
+		paths = append(paths, Path(path))
+	}
+}
+
+func (state WorkState) ToProperties() Properties {
+	properties := Properties{
+		"kind":         state.Kind,
+		"processed_by": joinProcessorIds(state.ProcessedBy),
+	}
+
+	if state.Kind == WorkKindDispatch && len(state.ProcessorId) > 0 {
+		// the dispatch is being replayed to a single processor
+		properties["processor_id"] = string(state.ProcessorId)
+	}
+
+	if state.Kind == WorkKindRun {
+		properties["status"] = state.Status
+		properties["processor_id"] = string(state.ProcessorId)
+		properties["input_dir"] = string(state.InputPath)
+		properties["output_dir"] = string(state.OutputPath)
+		properties["attempt"] = strconv.Itoa(state.Attempt)
+		putPaths(properties, "failed_output_dir", state.FailedOutputPaths)
+		properties["transient_retries"] = strconv.Itoa(state.TransientRetries)
+
+		if len(state.QuarantinePath) > 0 {
+			properties["quarantine_dir"] = string(state.QuarantinePath)
+		}
+	}
+
+	return properties
+}
+
+func WorkStateFromProperties(properties Properties) (WorkState, error) {
+	state := WorkState{}
+	kind, found := properties["kind"]
+
+	if !found {
+		return WorkState{}, fmt.Errorf("Work state missing kind property")
+	}
+
+	state.Kind = kind
+	state.ProcessedBy = splitProcessorIds(properties["processed_by"])
+
+	switch kind {
+	case WorkKindDispatch:
+		state.ProcessorId = ProcessorId(properties["processor_id"])
+		return state, nil
+	case WorkKindRun:
+		for _, key := range []string{"status", "processor_id", "input_dir", "output_dir"} {
+			if _, found := properties[key]; !found {
+				return WorkState{}, fmt.Errorf("Run work state missing %s property", key)
+			}
+		}
+
+		state.Status = properties["status"]
+		state.ProcessorId = ProcessorId(properties["processor_id"])
+		state.InputPath = Path(properties["input_dir"])
+		state.OutputPath = Path(properties["output_dir"])
+		state.FailedOutputPaths = getPaths(properties, "failed_output_dir")
+		state.QuarantinePath = Path(properties["quarantine_dir"])
+
+		if attemptStr, found := properties["attempt"]; found {
+			attempt, err := strconv.Atoi(attemptStr)
+
+			if err != nil {
+				return WorkState{}, fmt.Errorf("Invalid attempt in run work state: %s", err.Error())
+			}
+
+			state.Attempt = attempt
+		}
+
+		if retriesStr, found := properties["transient_retries"]; found {
+			retries, err := strconv.Atoi(retriesStr)
+
+			if err != nil {
+				return WorkState{}, fmt.Errorf("Invalid transient_retries in run work state: %s", err.Error())
+			}
+
+			state.TransientRetries = retries
+		}
+
+		return state, nil
+	}
+
+	return WorkState{}, fmt.Errorf("Unknown work state kind %s", kind)
+}
+
+func ReadWorkState(dir Path) (WorkState, error) {
+	properties, err := PropertiesFromFile(StatePathFor(dir))
+
+	if err != nil {
+		return WorkState{}, err
+	}
+
+	return WorkStateFromProperties(properties)
+}
+
+func WriteWorkState(dir Path, state WorkState) error {
+	return StatePathFor(dir).WriteBytes(state.ToProperties().ToBytes())
+}
+
+func RemoveWorkState(dir Path) error {
+	statePath := StatePathFor(dir)
+
+	if !statePath.Exists() {
+		return nil
+	}
+
+	return statePath.Unlink()
+}
+
+// UpdateRunStatus rewrites the status of the run whose input directory is
+// inputPath, leaving the rest of the state alone.
+func UpdateRunStatus(inputPath Path, status string) error {
+	state, err := ReadWorkState(inputPath)
+
+	if err != nil {
+		return err
+	}
+
+	state.Status = status
+	return WriteWorkState(inputPath, state)
+}
@@ -0,0 +1,102 @@
+package minnow
+
+import (
+	"testing"
+)
+
+func TestWorkStateRoundTrip(t *testing.T) {
+	dir := Path("/tmp/minnow-" + randomString(20))
+	processedBy := []ProcessorId{ProcessorId("/procs/a"), ProcessorId("/procs/b")}
+	state := WorkState{WorkKindRun, RunStatusQueued, ProcessorId("/procs/c"), processedBy, dir, Path("/tmp/out"), 2, []Path{Path("/tmp/out1")}, 0, ""}
+
+	err := WriteWorkState(dir, state)
+
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	defer RemoveWorkState(dir)
+	err = UpdateRunStatus(dir, RunStatusRunning)
+
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	readState, err := ReadWorkState(dir)
+
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if readState.Status != RunStatusRunning {
+		t.Errorf("Incorrect Status %s", readState.Status)
+	}
+
+	if readState.ProcessorId != state.ProcessorId || readState.InputPath != dir || readState.OutputPath != state.OutputPath {
+		t.Errorf("Run state did not round trip: %v", readState)
+	}
+
+	if readState.Attempt != 2 || len(readState.FailedOutputPaths) != 1 || readState.FailedOutputPaths[0] != Path("/tmp/out1") {
+		t.Errorf("Incorrect attempt history: %v", readState)
+	}
+
+	if len(readState.ProcessedBy) != 2 || readState.ProcessedBy[0] != processedBy[0] || readState.ProcessedBy[1] != processedBy[1] {
+		t.Errorf("Incorrect ProcessedBy %v", readState.ProcessedBy)
+	}
+}
+
+func TestWorkStateFailedOutputPaths(t *testing.T) {
+	workPath := Path("/tmp/minnow-work-" + randomString(20))
+	workPath.Mkdir()
+	defer workPath.RmdirRecursive()
+
+	// work directory names have colons in their timestamps
+	failedOutputPaths := make([]Path, 0)
+
+	for i := 0; i < 3; i++ {
+		failedOutputPath, err := makeRandomPath(workPath, "output")
+
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		failedOutputPaths = append(failedOutputPaths, failedOutputPath)
+	}
+
+	inputPath := workPath.JoinPath("input")
+	state := WorkState{WorkKindRun, RunStatusFailed, ProcessorId("/procs/c"), make([]ProcessorId, 0), inputPath, workPath.JoinPath("output"), 3, failedOutputPaths, 0, ""}
+	err := WriteWorkState(inputPath, state)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	readState, err := ReadWorkState(inputPath)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if len(readState.FailedOutputPaths) != len(failedOutputPaths) {
+		t.Fatalf("Expected %v, got %v", failedOutputPaths, readState.FailedOutputPaths)
+	}
+
+	for i, failedOutputPath := range failedOutputPaths {
+		if readState.FailedOutputPaths[i] != failedOutputPath {
+			t.Errorf("Expected %s, got %s", failedOutputPath, readState.FailedOutputPaths[i])
+		}
+	}
+}
+
+func TestDispatchWorkStateEmptyProcessedBy(t *testing.T) {
+	properties := WorkState{Kind: WorkKindDispatch}.ToProperties()
+	state, err := WorkStateFromProperties(properties)
+
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	if len(state.ProcessedBy) != 0 {
+		t.Errorf("Expected empty ProcessedBy, got %v", state.ProcessedBy)
+	}
+}